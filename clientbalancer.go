@@ -11,21 +11,9 @@ import (
 	"github.com/nknorg/nkngomobile"
 )
 
-var clientSendIndex = 0
-
-func getNextClient() *nkn.Client {
-	clientId := clientSendIndex % NUM_SUB_CLIENTS
-	client := client.GetClient(clientId)
-	clientSendIndex++
-
-	if client == nil {
-		client = getNextClient()
-	}
-
-	return client
-}
-
-func publish(data []byte) {
+// publish broadcasts data to all viewers, a source quality segment chunk is dropped when a newer segment is queued
+// before it is sent.
+func publish(data []byte, chunk bool) {
 	//Foreach chunk generate a message id and predefine the payload to reuse
 	msgId, _ := nkn.RandomBytes(nkn.MessageIDSize)
 	msgPayload := &payloads.Payload{
@@ -35,9 +23,14 @@ func publish(data []byte) {
 		Data:      data,
 	}
 
-	//Send VIEWER_SUB_CLIENTS times everytime with the next subclient in queue
+	//Send VIEWER_SUB_CLIENTS times, the scheduler spreads them over the subclients
+	addresses := viewers.SubClientAddresses()
 	for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-		go getNextClient().SendPayload(viewerSubClientAddresses[i], msgPayload, segmentSendConfig)
+		if chunk {
+			sender.SendChunk(0, addresses[i], msgPayload, segmentSendConfig)
+		} else {
+			sender.Send(addresses[i], msgPayload, segmentSendConfig)
+		}
 	}
 }
 
@@ -52,10 +45,12 @@ func publishQualityLevels(qualityData ...[][]byte) {
 	}
 
 	// Build viewer lists for each quality
+	viewers.mutex.RLock()
 	for k, _ := range viewers.messages {
-		qualityLevel := min(viewers.viewerQuality[k], qualityLevels)
+		qualityLevel := min(viewers.viewerQuality[k], qualityLevels-1)
 		qualityAddrStrings[qualityLevel] = append(qualityAddrStrings[qualityLevel], k)
 	}
+	viewers.mutex.RUnlock()
 
 	// Convert to multiclient recipient nkn addreses
 	for q := 0; q < qualityLevels; q++ {
@@ -81,7 +76,7 @@ func publishQualityLevels(qualityData ...[][]byte) {
 			}
 
 			for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-				go getNextClient().SendPayload(qualityNknAddrStrings[q][i], msgPayload, segmentSendConfig)
+				sender.SendChunk(q, qualityNknAddrStrings[q][i], msgPayload, segmentSendConfig)
 			}
 		}
 	}
@@ -103,9 +98,10 @@ func publishText(text string) {
 		Data:      data,
 	}
 
-	//Send VIEWER_SUB_CLIENTS times everytime with the next subclient in queue
+	//Send VIEWER_SUB_CLIENTS times, the scheduler spreads them over the subclients
+	addresses := viewers.SubClientAddresses()
 	for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-		go getNextClient().SendPayload(viewerSubClientAddresses[i], msgPayload, segmentSendConfig)
+		sender.Send(addresses[i], msgPayload, segmentSendConfig)
 	}
 }
 
@@ -119,7 +115,7 @@ func sendToClient(address string, data []byte) {
 	}
 
 	for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-		go getNextClient().SendPayload(nkn.NewStringArray("__"+strconv.Itoa(i)+"__."+address), msgPayload, &nkn.MessageConfig{
+		sender.Send(nkn.NewStringArray("__"+strconv.Itoa(i)+"__."+address), msgPayload, &nkn.MessageConfig{
 			Unencrypted:       true,
 			NoReply:           true,
 			MaxHoldingSeconds: 0,
@@ -134,7 +130,7 @@ func reply(data []byte, msg *nkn.Message) {
 	}
 
 	for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-		go getNextClient().SendPayload(nkn.NewStringArray("__"+strconv.Itoa(i)+"__."+msg.Src), payload, &nkn.MessageConfig{
+		sender.Send(nkn.NewStringArray("__"+strconv.Itoa(i)+"__."+msg.Src), payload, &nkn.MessageConfig{
 			Unencrypted:       true,
 			NoReply:           true,
 			MaxHoldingSeconds: 0,
@@ -149,7 +145,7 @@ func replyText(text string, msg *nkn.Message) {
 	}
 
 	for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-		go getNextClient().SendPayload(nkn.NewStringArray("__"+strconv.Itoa(i)+"__."+msg.Src), payload, &nkn.MessageConfig{
+		sender.Send(nkn.NewStringArray("__"+strconv.Itoa(i)+"__."+msg.Src), payload, &nkn.MessageConfig{
 			Unencrypted:       true,
 			NoReply:           true,
 			MaxHoldingSeconds: 0,
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const DEFAULT_CLIP_SECONDS = 30
+const MAX_CLIP_SECONDS = 120
+
+// Clip is the metadata of a clip, stored next to the mp4 file.
+type Clip struct {
+	Id          string        `json:"id"`
+	Requester   string        `json:"requester"`
+	Created     time.Time     `json:"created"`
+	Seconds     int           `json:"seconds"`
+	Size        int           `json:"size"`
+	Chunks      int           `json:"chunks"`
+	ChatExcerpt []ChatMessage `json:"chatExcerpt"`
+}
+
+type CreateClip struct {
+	Seconds int `json:"seconds"`
+}
+
+type GetClip struct {
+	Id    string `json:"id"`
+	Chunk int    `json:"chunk"`
+}
+
+func clipPath() string {
+	if path := currentConfig().ClipPath; path != "" {
+		return path
+	}
+	return "./clips"
+}
+
+// HandleCreateClip saves the last seconds of the dvr window as an mp4 clip, owner and moderators only.
+func HandleCreateClip(content json.RawMessage, nknMessage *nkn.Message) {
+	if !isOwnerOrModerator(nknMessage.Src) {
+		replyText("error: only the owner and moderators can create clips", nknMessage)
+		return
+	}
+
+	request := CreateClip{Seconds: DEFAULT_CLIP_SECONDS}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &request); err != nil {
+			replyText("error: invalid clip request", nknMessage)
+			return
+		}
+	}
+
+	go func() {
+		clip, err := createClip(nknMessage.Src, request.Seconds)
+		if err != nil {
+			log.Println("error creating clip", err.Error())
+			replyText("error: "+err.Error(), nknMessage)
+			return
+		}
+
+		response, _ := json.Marshal(clip)
+		replyText(string(response), nknMessage)
+	}()
+}
+
+// HandleListClips replies with the metadata of all saved clips, newest first.
+func HandleListClips(nknMessage *nkn.Message) {
+	clips, err := listClips()
+	if err != nil {
+		replyText("error: "+err.Error(), nknMessage)
+		return
+	}
+
+	response, _ := json.Marshal(clips)
+	replyText(string(response), nknMessage)
+}
+
+// HandleGetClip replies with a single CHUNK_SIZE chunk of the clip mp4 file.
+func HandleGetClip(content json.RawMessage, nknMessage *nkn.Message) {
+	var request GetClip
+	if err := json.Unmarshal(content, &request); err != nil {
+		replyText("error: invalid clip request", nknMessage)
+		return
+	}
+
+	if _, err := hex.DecodeString(request.Id); err != nil || request.Id == "" {
+		replyText("error: invalid clip id", nknMessage)
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(clipPath(), request.Id+".mp4"))
+	if err != nil {
+		replyText("error: clip not found", nknMessage)
+		return
+	}
+
+	start := request.Chunk * CHUNK_SIZE
+	if request.Chunk < 0 || start >= len(data) {
+		replyText("error: chunk out of range", nknMessage)
+		return
+	}
+
+	reply(data[start:min(start+CHUNK_SIZE, len(data))], nknMessage)
+}
+
+func createClip(requester string, seconds int) (*Clip, error) {
+	if seconds <= 0 || seconds > MAX_CLIP_SECONDS {
+		return nil, fmt.Errorf("clip length must be between 1 and %v seconds", MAX_CLIP_SECONDS)
+	}
+
+	since := time.Now().Add(-time.Duration(seconds) * time.Second)
+	segments, err := dvr.Segments(since, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("no buffered segments to clip")
+	}
+
+	rngBytes, _ := nkn.RandomBytes(8)
+	clip := &Clip{
+		Id:          hex.EncodeToString(rngBytes),
+		Requester:   requester,
+		Created:     time.Now(),
+		Seconds:     seconds,
+		ChatExcerpt: chatSince(since),
+	}
+
+	if err := os.MkdirAll(clipPath(), 0755); err != nil {
+		return nil, err
+	}
+
+	clipFile := filepath.Join(clipPath(), clip.Id+".mp4")
+	if err := remuxToMp4(bytes.Join(segments, nil), clipFile); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(clipFile)
+	if err != nil {
+		return nil, err
+	}
+	clip.Size = int(info.Size())
+	clip.Chunks = (clip.Size + CHUNK_SIZE - 1) / CHUNK_SIZE
+
+	metadata, err := json.MarshalIndent(clip, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(clipPath(), clip.Id+".json"), metadata, 0644); err != nil {
+		return nil, err
+	}
+
+	log.Println("Clip created:", clip.Id, "seconds:", seconds, "requested by:", requester)
+	return clip, nil
+}
+
+func listClips() ([]*Clip, error) {
+	entries, err := os.ReadDir(clipPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Clip{}, nil
+		}
+		return nil, err
+	}
+
+	clips := make([]*Clip, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(clipPath(), entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		clip := &Clip{}
+		if err := json.Unmarshal(data, clip); err != nil {
+			continue
+		}
+		clips = append(clips, clip)
+	}
+
+	sort.Slice(clips, func(i, j int) bool {
+		return clips[i].Created.After(clips[j].Created)
+	})
+
+	return clips, nil
+}
+
+// remuxToMp4 concatenates MPEG-TS data into an mp4 file without re-encoding.
+func remuxToMp4(segment []byte, output string) error {
+	_, stderr, err := commands.Run("ffmpeg", []string{
+		"-y",
+		"-i", "-", // read from stdin (pipe)
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		output}, segment)
+
+	if err != nil {
+		log.Println("FFmpeg stderr:", string(stderr))
+		return fmt.Errorf("error remuxing clip: %w", err)
+	}
+
+	return nil
+}
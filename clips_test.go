@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setClipHost gives the host a dvr with the window and a clip directory, both are removed after the test.
+func setClipHost(t *testing.T, window time.Duration) string {
+	t.Helper()
+
+	resetHost()
+	path := t.TempDir()
+	hostConfig.Store(&Config{Title: "test", ClipPath: path})
+	dvr = &DVR{window: window}
+	t.Cleanup(func() { dvr = nil })
+	return path
+}
+
+func dvrChunks(segment []byte) [][][]byte {
+	return [][][]byte{ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, 0)}
+}
+
+func TestCreateClip(t *testing.T) {
+	path := setClipHost(t, time.Minute)
+	dvr.Add(1, dvrChunks([]byte("first")))
+	dvr.Add(2, dvrChunks([]byte("second")))
+
+	clip, err := createClip("viewer", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clip.Requester != "viewer" || clip.Seconds != 10 || clip.Size != len("firstsecond") || clip.Chunks != 1 {
+		t.Errorf("unexpected clip %+v", clip)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(path, clip.Id+".mp4")); err != nil || !bytes.Equal(data, []byte("firstsecond")) {
+		t.Errorf("expected the segments in the clip, got %q %v", data, err)
+	}
+
+	var saved Clip
+	data, _ := os.ReadFile(filepath.Join(path, clip.Id+".json"))
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Id != clip.Id {
+		t.Errorf("expected the clip metadata, got %s", data)
+	}
+	if clips, err := listClips(); err != nil || len(clips) != 1 || clips[0].Id != clip.Id {
+		t.Errorf("expected the clip to be listed, got %v %v", clips, err)
+	}
+}
+
+func TestCreateClipDurationBounds(t *testing.T) {
+	setClipHost(t, time.Minute)
+	dvr.Add(1, dvrChunks([]byte("segment")))
+
+	for seconds, valid := range map[int]bool{-1: false, 0: false, 1: true, MAX_CLIP_SECONDS: true, MAX_CLIP_SECONDS + 1: false} {
+		if _, err := createClip("viewer", seconds); (err == nil) != valid {
+			t.Errorf("%v seconds: expected valid %v, got %v", seconds, valid, err)
+		}
+	}
+}
+
+func TestCreateClipLeavesOutEvictedSegments(t *testing.T) {
+	path := setClipHost(t, 50*time.Millisecond)
+	dvr.Add(1, dvrChunks([]byte("evicted")))
+	time.Sleep(100 * time.Millisecond)
+
+	//The segment is outside of the window, even though no Add evicted it yet
+	if _, err := createClip("viewer", 30); err == nil {
+		t.Error("expected an error for a clip of evicted segments")
+	}
+
+	dvr.Add(2, dvrChunks([]byte("kept")))
+	clip, err := createClip("viewer", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(path, clip.Id+".mp4")); !bytes.Equal(data, []byte("kept")) {
+		t.Errorf("expected only the segment in the window, got %q", data)
+	}
+}
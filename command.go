@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var commands CommandRunner = &execRunner{running: make(map[*exec.Cmd]struct{})}
+
+var errCommandsStopped = errors.New("commands are stopped")
+
+// CommandRunner runs the ffmpeg and ffprobe subprocesses, tests replace it with canned outputs.
+type CommandRunner interface {
+	Run(name string, args []string, stdin []byte) (stdout []byte, stderr []byte, err error)
+	// Start runs a long running subprocess, kill stops it early and exited receives its exit error.
+	Start(name string, args []string) (kill func(), exited <-chan error, err error)
+}
+
+// execRunner keeps track of its running subprocesses so they can be killed on shutdown.
+type execRunner struct {
+	running map[*exec.Cmd]struct{}
+	stopped bool
+	mutex   sync.Mutex
+}
+
+func (r *execRunner) Run(name string, args []string, stdin []byte) ([]byte, []byte, error) {
+	cmd := exec.Command(name, args...)
+
+	var stdoutPipe, stderrPipe bytes.Buffer
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdoutPipe
+	cmd.Stderr = &stderrPipe
+
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return nil, nil, errCommandsStopped
+	}
+	if err := cmd.Start(); err != nil {
+		r.mutex.Unlock()
+		return nil, nil, err
+	}
+	r.running[cmd] = struct{}{}
+	r.mutex.Unlock()
+
+	err := cmd.Wait()
+
+	r.mutex.Lock()
+	delete(r.running, cmd)
+	r.mutex.Unlock()
+
+	return stdoutPipe.Bytes(), stderrPipe.Bytes(), err
+}
+
+func (r *execRunner) Start(name string, args []string) (func(), <-chan error, error) {
+	cmd := exec.Command(name, args...)
+
+	var stderrPipe bytes.Buffer
+	cmd.Stderr = &stderrPipe
+
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return nil, nil, errCommandsStopped
+	}
+	if err := cmd.Start(); err != nil {
+		r.mutex.Unlock()
+		return nil, nil, err
+	}
+	r.running[cmd] = struct{}{}
+	r.mutex.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+
+		r.mutex.Lock()
+		delete(r.running, cmd)
+		r.mutex.Unlock()
+
+		//The last line ffmpeg printed says why it stopped
+		if lines := strings.Split(strings.TrimSpace(stderrPipe.String()), "\n"); err != nil && lines[len(lines)-1] != "" {
+			err = fmt.Errorf("%w: %v", err, lines[len(lines)-1])
+		}
+		exited <- err
+	}()
+
+	return func() { cmd.Process.Kill() }, exited, nil
+}
+
+// StopAll kills the running subprocesses and refuses to start new ones.
+func (r *execRunner) StopAll() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stopped = true
+	for cmd := range r.running {
+		cmd.Process.Kill()
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,16 +8,45 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/nknorg/nkn-sdk-go"
 )
 
 // Config represents the configuration data
 type Config struct {
-	Seed        string   `json:"seed"`
+	Seed        string   `json:"seed,omitempty"`
+	Keystore    string   `json:"keystore,omitempty"`
 	Title       string   `json:"title"`
 	Owner       string   `json:"owner"`
-	Transcoders []string `json:transcoders`
+	Transcoders []string `json:"transcoders"`
+
+	Category string   `json:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Language string   `json:"language,omitempty"`
+	Mature   bool     `json:"mature,omitempty"`
+
+	Record          bool   `json:"record,omitempty"`
+	RecordPath      string `json:"recordPath,omitempty"`
+	RecordMaxAge    string `json:"recordMaxAge,omitempty"`
+	RecordMaxSizeMB int    `json:"recordMaxSizeMB,omitempty"`
+
+	Reruns    []string `json:"reruns,omitempty"`
+	RerunLoop bool     `json:"rerunLoop,omitempty"`
+
+	DvrMinutes int    `json:"dvrMinutes,omitempty"`
+	DvrPath    string `json:"dvrPath,omitempty"`
+
+	Moderators []string `json:"moderators,omitempty"`
+	ClipPath   string   `json:"clipPath,omitempty"`
+
+	Relay string `json:"relay,omitempty"`
+
+	Ingest        []string `json:"ingest,omitempty"`
+	SrtPassphrase string   `json:"srtPassphrase,omitempty"`
+
+	Source         string `json:"source,omitempty"`
+	SourceOnDemand bool   `json:"sourceOnDemand,omitempty"`
+
+	ChatLog        string `json:"chatLog,omitempty"`
+	DonationLedger string `json:"donationLedger,omitempty"`
 }
 
 type Transcode struct {
@@ -37,25 +65,49 @@ func NewConfig(configFile string) (*Config, error) {
 	if err != nil {
 		if os.IsNotExist(err) {
 
-			//generate a wallet
-			newWallet, _ := nkn.NewAccount(nil)
-			seedStr := hex.EncodeToString(newWallet.Seed())
-			// Create a default configuration
-			defaultConfig := &Config{Seed: seedStr, Title: "Unnamed Stream"}
+			// Create a default configuration, with a new wallet in an encrypted keystore unless the seed is provided separately
+			defaultConfig := &Config{Title: "Unnamed Stream"}
+			if !seedOverridden() {
+				defaultConfig.Keystore = DEFAULT_KEYSTORE
+				if err := createDefaultKeystore(keystorePath(configFile, DEFAULT_KEYSTORE)); err != nil {
+					return nil, err
+				}
+			}
 			data, err := json.MarshalIndent(defaultConfig, "", "  ")
 			if err != nil {
 				return nil, err
 			}
-			err = os.WriteFile(configFile, data, 0644) // Set appropriate permissions
+			err = os.WriteFile(configFile, data, 0600)
 			if err != nil {
 				return nil, fmt.Errorf("error creating config file: %w", err)
 			}
-			return defaultConfig, nil
+		} else {
+			return nil, err
 		}
+	}
+
+	cfg, err := readConfig(configFile)
+	if err != nil {
 		return nil, err
 	}
 
-	// Open the existing file and decode data
+	// Fail on startup with every problem, instead of later on the first one used
+	if problems := cfg.Validate(); len(problems) > 0 {
+		return nil, fmt.Errorf("%v has problems, run gonovon config check for details:\n%w", configFile, errors.Join(problems...))
+	}
+
+	if cfg.Seed != "" && !seedOverridden() {
+		fmt.Println("Warning:", configFile, "holds the wallet seed in plaintext, run gonovon keystore migrate to encrypt it")
+	}
+	if err := unlockKeystore(configFile, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readConfig decodes an existing configuration file, applies the environment overrides and populates defaults
+func readConfig(configFile string) (*Config, error) {
 	f, err := os.Open(configFile)
 	if err != nil {
 		return nil, err
@@ -69,6 +121,10 @@ func NewConfig(configFile string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := applyConfigOverrides(&cfg); err != nil {
+		return nil, err
+	}
+
 	// Set defaults for missing fields
 	if cfg.Title == "" {
 		cfg.Title = "Unnamed Stream"
@@ -77,31 +133,48 @@ func NewConfig(configFile string) (*Config, error) {
 	return &cfg, nil
 }
 
-func getTranscoders(config *Config) []Transcode {
+// parseTranscode parses a "<resolution>p[<framerate>]" transcode value, the framerate defaults to 30.
+func parseTranscode(value string) (Transcode, error) {
+	transcodeStr := strings.Split(value, "p")
+	if len(transcodeStr) > 2 {
+		return Transcode{}, fmt.Errorf("invalid transcode value: %v", value)
+	}
+
+	resolution, err := strconv.Atoi(transcodeStr[0])
+	if err != nil {
+		return Transcode{}, fmt.Errorf("invalid transcode value: %v", value)
+	}
+
+	framerate := 30
+	if len(transcodeStr) == 2 && len(transcodeStr[1]) > 0 {
+		framerate, err = strconv.Atoi(transcodeStr[1])
+		if err != nil {
+			return Transcode{}, fmt.Errorf("invalid transcode value: %v", value)
+		}
+	}
+
+	return Transcode{Resolution: resolution, Framerate: framerate}, nil
+}
+
+// getTranscoders returns the transcode ladder of config.json that fits the source.
+func getTranscoders(config *Config, source *SourceInfo) []Transcode {
+	sourceResolution, sourceFramerate := source.Resolution, source.Framerate
 	var transcoders = make([]Transcode, 0)
 
 	for _, v := range config.Transcoders {
-		transcodeStr := strings.Split(v, "p")
-		resolution, err := strconv.Atoi(transcodeStr[0])
+		transcode, err := parseTranscode(v)
 		if err != nil {
 			fmt.Println("Skipping invalid transcode value in config:", v)
 			continue
 		}
+		resolution := transcode.Resolution
+		framerate := transcode.Framerate
 
 		if sourceResolution <= resolution {
 			fmt.Println("Skipping transcode value in config:", v, "stream source is smaller:", sourceResolution)
 			continue
 		}
 
-		framerate := 30
-		if len(transcodeStr) == 2 && len(transcodeStr[1]) > 0 {
-			framerate, err = strconv.Atoi(transcodeStr[1])
-			if err != nil {
-				fmt.Println("Skipping invalid transcode value in config:", v)
-				continue
-			}
-		}
-
 		if framerate > sourceFramerate {
 			framerate = sourceFramerate
 			fmt.Println("Lowering transcode framerate value in config:", v, "stream source framerate:", sourceFramerate)
@@ -147,10 +220,19 @@ func removeDuplicateTranscodes(transcodes []Transcode) []Transcode {
 	return unique
 }
 
+// generateMediaMTXConfig writes the default mediamtx.yml with a new stream key, and prints the key.
 func generateMediaMTXConfig() {
-	if _, err := os.Stat("mediamtx.yml"); errors.Is(err, os.ErrNotExist) {
-		os.WriteFile("mediamtx.yml", []byte(mediaMTXDefaults), 0644)
+	if _, err := os.Stat(MEDIAMTX_CONFIG); !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	key, credential := newStreamKey()
+	yml, _ := setStreamKey([]byte(mediaMTXDefaults), credential)
+	if err := os.WriteFile(MEDIAMTX_CONFIG, yml, 0644); err != nil {
+		fmt.Println("Error creating", MEDIAMTX_CONFIG, err)
+		return
 	}
+	printStreamKey(key)
 }
 
 const mediaMTXDefaults = `###############################################
@@ -222,18 +304,7 @@ authInternalUsers:
   # IPs or networks allowed to use this user. An empty list means any IP.
   ips: []
   # List of permissions.
-  permissions:
-    # Available actions are: publish, read, playback, api, metrics, pprof.
-  - action: publish
-    # Paths can be set to further restrict access to a specific path.
-    # An empty path means any path.
-    # Regular expressions can be used by using a tilde as prefix.
-    path:
-  - action: read
-    path:
-  - action: playback
-    path:
-
+` + anyUserPermissions + `
   # Default administrator.
   # This allows to use API, metrics and PPROF without authentication,
   # if the IP is localhost.
@@ -291,9 +362,11 @@ authJWTJWKS:
 # Global settings -> API
 
 # Enable controlling the server through the API.
-api: no
+# gonovon uses it to keep a single path publishing. Local users can use it
+# without credentials, set to no to turn it and the path check off.
+api: yes
 # Address of the API listener.
-apiAddress: :9997
+apiAddress: 127.0.0.1:9997
 
 ###############################################
 # Global settings -> Playback server
@@ -553,6 +626,7 @@ pathDefaults:
   # Default path settings -> Publisher source (when source is "publisher")
 
   # Allow another client to disconnect the current publisher and publish in its place.
+  # Only clients with the stream key can publish, this lets the encoder reconnect.
   overridePublisher: yes
   # SRT encryption passphrase required to publish to this path
   srtPublishPassphrase:
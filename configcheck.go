@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const MIN_TRANSCODE_RESOLUTION = 144
+const MAX_TRANSCODE_RESOLUTION = 4320
+const MAX_TRANSCODE_FRAMERATE = 120
+
+// Validate checks the config values, it returns every problem found instead of stopping at the first.
+func (c *Config) Validate() []error {
+	problems := make([]error, 0)
+
+	seed, err := hex.DecodeString(c.Seed)
+	switch {
+	case c.Seed == "" && c.Keystore != "":
+		//Unlocked after validating
+	case c.Seed == "":
+		problems = append(problems, errors.New("seed: missing, set a keystore, GONOVON_SEED or --seed-file"))
+	case err != nil:
+		problems = append(problems, fmt.Errorf("seed: not hex encoded: %w", err))
+	case len(seed) != 32:
+		problems = append(problems, fmt.Errorf("seed: expected 64 hex characters, got %v", len(c.Seed)))
+	}
+
+	if c.Owner != "" {
+		if err := validateClientAddress(c.Owner); err != nil {
+			problems = append(problems, fmt.Errorf("owner: %w", err))
+		}
+	}
+	for i, moderator := range c.Moderators {
+		if err := validateClientAddress(moderator); err != nil {
+			problems = append(problems, fmt.Errorf("moderators[%v]: %w", i, err))
+		}
+	}
+	for _, duplicate := range duplicates(c.Moderators) {
+		problems = append(problems, fmt.Errorf("moderators: %v is listed more than once", duplicate))
+	}
+
+	for i, value := range c.Transcoders {
+		transcode, err := parseTranscode(value)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("transcoders[%v]: %q is not <resolution>p[<framerate>], like 720p30", i, value))
+			continue
+		}
+		if transcode.Resolution < MIN_TRANSCODE_RESOLUTION || transcode.Resolution > MAX_TRANSCODE_RESOLUTION {
+			problems = append(problems, fmt.Errorf("transcoders[%v]: resolution %v is outside %v-%v", i, transcode.Resolution, MIN_TRANSCODE_RESOLUTION, MAX_TRANSCODE_RESOLUTION))
+		}
+		if transcode.Framerate < 1 || transcode.Framerate > MAX_TRANSCODE_FRAMERATE {
+			problems = append(problems, fmt.Errorf("transcoders[%v]: framerate %v is outside 1-%v", i, transcode.Framerate, MAX_TRANSCODE_FRAMERATE))
+		}
+	}
+	for _, duplicate := range duplicates(c.Transcoders) {
+		problems = append(problems, fmt.Errorf("transcoders: %v is listed more than once", duplicate))
+	}
+
+	if c.RecordMaxAge != "" {
+		if _, err := time.ParseDuration(c.RecordMaxAge); err != nil {
+			problems = append(problems, fmt.Errorf("recordMaxAge: %q is not a duration, like 168h", c.RecordMaxAge))
+		}
+	}
+	if c.RecordMaxSizeMB < 0 {
+		problems = append(problems, errors.New("recordMaxSizeMB: can not be negative"))
+	}
+	if c.DvrMinutes < 0 {
+		problems = append(problems, errors.New("dvrMinutes: can not be negative"))
+	}
+	if c.Relay != "" {
+		if err := validateClientAddress(c.Relay); err != nil {
+			problems = append(problems, fmt.Errorf("relay: %w", err))
+		}
+	}
+	problems = append(problems, validateIngest(c)...)
+	problems = append(problems, validateSource(c)...)
+
+	return problems
+}
+
+// validateClientAddress checks an NKN client address, the form viewers are known by in chat.
+func validateClientAddress(address string) error {
+	if strings.HasPrefix(address, "NKN") {
+		return fmt.Errorf("%v is a wallet address, use the client address (public key) instead", address)
+	}
+	if _, err := nkn.ClientAddrToPubKey(address); err != nil {
+		return fmt.Errorf("%v is not a client address: %w", address, err)
+	}
+	return nil
+}
+
+func duplicates(values []string) []string {
+	seen := make(map[string]bool)
+	duplicates := make([]string, 0)
+	for _, value := range values {
+		if seen[value] {
+			duplicates = append(duplicates, value)
+		}
+		seen[value] = true
+	}
+	return duplicates
+}
+
+// checkConfigFile reports the problems of a config file: invalid JSON, unknown and duplicate fields, and every
+// problem Validate finds after the environment overrides are applied.
+func checkConfigFile(configFile string) []error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return []error{err}
+	}
+
+	problems := checkConfigFields(data)
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return append(problems, err)
+	}
+	if err := applyConfigOverrides(&cfg); err != nil {
+		return append(problems, err)
+	}
+	if cfg.Seed == "" && cfg.Keystore != "" {
+		if _, err := os.Stat(keystorePath(configFile, cfg.Keystore)); err != nil {
+			problems = append(problems, fmt.Errorf("keystore: %w", err))
+		}
+	}
+	return append(problems, cfg.Validate()...)
+}
+
+// checkConfigFields reports top level fields that are unknown or occur more than once. Keys match fields without
+// regard to case like encoding/json does, configs written before the json tags were fixed use "Transcoders".
+func checkConfigFields(data []byte) []error {
+	known := make([]string, 0)
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		name, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+		known = append(known, name)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return []error{errors.New("config is not a JSON object")}
+	}
+
+	problems := make([]error, 0)
+	seen := make(map[string]bool)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return append(problems, err)
+		}
+		key := token.(string)
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return append(problems, err)
+		}
+
+		field := ""
+		for _, name := range known {
+			if strings.EqualFold(key, name) {
+				field = name
+				break
+			}
+		}
+		if field == "" {
+			problems = append(problems, fmt.Errorf("%v: unknown field, check the spelling", key))
+			continue
+		}
+		if seen[field] {
+			problems = append(problems, fmt.Errorf("%v: field is set more than once, only the last value is used", key))
+		}
+		seen[field] = true
+	}
+	return problems
+}
+
+// runConfig checks the config file: gonovon config check [file]
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: gonovon config check [file]")
+		os.Exit(2)
+	}
+
+	configFile := envOr("CONFIG", "./config.json")
+	if len(args) > 1 {
+		configFile = args[1]
+	}
+
+	problems := checkConfigFile(configFile)
+	if len(problems) == 0 {
+		fmt.Println(configFile, "is valid")
+		return
+	}
+
+	fmt.Println(configFile, "has", len(problems), "problems:")
+	for _, problem := range problems {
+		fmt.Println(" -", problem)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigFileReportsEveryProblem(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(configFile, []byte(`{
+		"seed": "abcd",
+		"title": "test",
+		"title": "again",
+		"owner": "NKNxyz",
+		"transcoders": ["720p30", "hd", "720p30", "9000p"],
+		"recordMaxAge": "a week",
+		"trancoders": []
+	}`), 0600)
+
+	problems := checkConfigFile(configFile)
+
+	expected := []string{
+		"trancoders: unknown field",
+		"title: field is set more than once",
+		"seed: expected 64 hex characters",
+		"owner: NKNxyz is a wallet address",
+		`transcoders[1]: "hd" is not`,
+		"transcoders[3]: resolution 9000",
+		"transcoders: 720p30 is listed more than once",
+		"recordMaxAge:",
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("expected %v problems, got %v: %v", len(expected), len(problems), problems)
+	}
+	for _, e := range expected {
+		found := false
+		for _, problem := range problems {
+			found = found || strings.HasPrefix(problem.Error(), e)
+		}
+		if !found {
+			t.Errorf("expected a problem starting with %q in %v", e, problems)
+		}
+	}
+}
+
+func TestValidateAcceptsGeneratedConfig(t *testing.T) {
+	cfg := &Config{
+		Seed:        strings.Repeat("ab", 32),
+		Owner:       mustTransport(t).Address(),
+		Moderators:  []string{"__0__." + mustTransport(t).Address()},
+		Transcoders: []string{"720p30", "480p"},
+	}
+	if problems := cfg.Validate(); len(problems) > 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+}
+
+func TestCheckConfigFileAcceptsBaselineConfig(t *testing.T) {
+	//Written by the first releases, their transcoders tag was missing its quotes
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(configFile, []byte(`{
+  "seed": "`+strings.Repeat("ab", 32)+`",
+  "title": "Unnamed Stream",
+  "owner": "",
+  "Transcoders": ["720p30"]
+}`), 0644)
+
+	if problems := checkConfigFile(configFile); len(problems) != 0 {
+		t.Errorf("expected the baseline config to be valid, got %v", problems)
+	}
+	if _, _, err := migrateSeed(configFile, "hunter2"); err != nil {
+		t.Errorf("expected the baseline config to migrate, got %v", err)
+	}
+
+	problems := checkConfigFields([]byte(`{"transcoders": [], "Transcoders": []}`))
+	if len(problems) != 1 || !strings.HasPrefix(problems[0].Error(), "Transcoders: field is set more than once") {
+		t.Errorf("expected keys differing in case to be the same field, got %v", problems)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gonovon/transport"
+	"gonovon/viewer"
+)
+
+const DIRECTORY_TOPIC = "novon"
+const DIRECTORY_CONCURRENCY = 16
+
+// DirectoryEntry is a subscriber of the novon topic with what it answered.
+type DirectoryEntry struct {
+	Address  string          `json:"address"`
+	Metadata ChannelMetadata `json:"metadata"`
+
+	Reachable     bool          `json:"reachable"`
+	Mode          string        `json:"mode,omitempty"`
+	Viewers       int           `json:"viewers"`
+	QualityLevels int           `json:"qualityLevels"`
+	Latency       time.Duration `json:"latency,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// runDirectory lists the channels subscribed to the novon topic: gonovon directory [flags]
+func runDirectory(args []string) {
+	flags := flag.NewFlagSet("directory", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 5*time.Second, "time to wait for every channel to answer")
+	sortBy := flags.String("sort", "viewers", "sort by viewers, title, latency or address")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	find := flags.String("find", "", "exit with status 1 when this address is not listed")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonovon directory [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	directoryClient := createViewerClient()
+	defer directoryClient.Close()
+
+	entries, err := queryDirectory(directoryClient, *timeout)
+	if err != nil {
+		log.Fatalln("could not get subscribers:", err)
+	}
+
+	if err := sortDirectory(entries, *sortBy); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(entries)
+	} else {
+		printDirectory(entries)
+	}
+
+	if *find != "" {
+		for _, entry := range entries {
+			if entry.Address == *find {
+				log.Println("Listed:", *find, "reachable:", entry.Reachable)
+				return
+			}
+		}
+		log.Println("Not listed:", *find)
+		os.Exit(1)
+	}
+}
+
+// queryDirectory asks every subscriber for its channel info and view count, at most DIRECTORY_CONCURRENCY at a time.
+func queryDirectory(client transport.Transport, timeout time.Duration) ([]*DirectoryEntry, error) {
+	subscribers, err := client.Subscribers(DIRECTORY_TOPIC)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*DirectoryEntry, 0, len(subscribers))
+	for address, meta := range subscribers {
+		entries = append(entries, &DirectoryEntry{Address: address, Metadata: parseChannelMetadata(meta)})
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, DIRECTORY_CONCURRENCY)
+	for _, entry := range entries {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(entry *DirectoryEntry) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			queryChannel(client, entry, timeout)
+		}(entry)
+	}
+	wg.Wait()
+
+	return entries, nil
+}
+
+func queryChannel(client transport.Transport, entry *DirectoryEntry, timeout time.Duration) {
+	v := viewer.New(client, entry.Address, &viewer.Config{RequestTimeout: timeout})
+
+	start := time.Now()
+	info, err := v.ChannelInfo()
+	if err != nil {
+		entry.Error = err.Error()
+		return
+	}
+	entry.Latency = time.Since(start)
+	entry.Reachable = true
+	entry.Mode = info.Mode
+	entry.Viewers = info.Viewers
+	entry.QualityLevels = len(info.QualityLevels)
+
+	//Only broadcasting hosts answer the view count
+	if count, err := v.Request("viewcount"); err == nil {
+		if viewers, err := strconv.Atoi(string(count)); err == nil {
+			entry.Viewers = viewers
+		}
+	}
+}
+
+// parseChannelMetadata reads the JSON subscription metadata, older hosts subscribe with just their title.
+func parseChannelMetadata(meta string) ChannelMetadata {
+	var metadata ChannelMetadata
+	if err := json.Unmarshal([]byte(meta), &metadata); err != nil {
+		return ChannelMetadata{Title: meta}
+	}
+	return metadata
+}
+
+func sortDirectory(entries []*DirectoryEntry, sortBy string) error {
+	var less func(a, b *DirectoryEntry) bool
+	switch sortBy {
+	case "viewers":
+		less = func(a, b *DirectoryEntry) bool { return a.Viewers > b.Viewers }
+	case "title":
+		less = func(a, b *DirectoryEntry) bool {
+			return strings.ToLower(a.Metadata.Title) < strings.ToLower(b.Metadata.Title)
+		}
+	case "latency":
+		less = func(a, b *DirectoryEntry) bool {
+			if a.Reachable != b.Reachable {
+				return a.Reachable
+			}
+			return a.Latency < b.Latency
+		}
+	case "address":
+		less = func(a, b *DirectoryEntry) bool { return a.Address < b.Address }
+	default:
+		return fmt.Errorf("unknown sort order: %v", sortBy)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if less(entries[i], entries[j]) != less(entries[j], entries[i]) {
+			return less(entries[i], entries[j])
+		}
+		return entries[i].Address < entries[j].Address
+	})
+	return nil
+}
+
+func printDirectory(entries []*DirectoryEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tTITLE\tCATEGORY\tLANG\tMODE\tVIEWERS\tLEVELS\tLATENCY")
+	for _, entry := range entries {
+		mode, viewers, levels, latency := "unreachable", "-", "-", "-"
+		if entry.Reachable {
+			mode = entry.Mode
+			viewers = strconv.Itoa(entry.Viewers)
+			levels = strconv.Itoa(entry.QualityLevels)
+			latency = entry.Latency.Round(time.Millisecond).String()
+		}
+		if entry.Metadata.Mature {
+			mode += " (mature)"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", shortAddress(entry.Address), entry.Metadata.Title,
+			entry.Metadata.Category, entry.Metadata.Language, mode, viewers, levels, latency)
+	}
+	w.Flush()
+
+	fmt.Println(len(entries), "channels")
+}
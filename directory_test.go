@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryDirectory(t *testing.T) {
+	resetHost()
+	publishTSPart(loadFixture(t))
+	waitFor(t, func() bool { return segmentId.Load() == 1 })
+
+	if err := client.Subscribe(DIRECTORY_TOPIC, 100, subscriptionMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(DIRECTORY_TOPIC)
+
+	// A channel that subscribed but does not answer, with the title only metadata of older hosts
+	offline := mustTransport(t)
+	offline.Subscribe(DIRECTORY_TOPIC, 100, "old channel")
+	defer offline.Unsubscribe(DIRECTORY_TOPIC)
+
+	entries, err := queryDirectory(mustTransport(t), 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sortDirectory(entries, "latency"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 channels, got %v", len(entries))
+	}
+	if entries[0].Address != client.Address() || !entries[0].Reachable || entries[0].Mode != MODE_LIVE {
+		t.Errorf("unexpected host entry: %+v", entries[0])
+	}
+	if entries[0].Metadata.Title != "test" || entries[0].Metadata.Version != PROTOCOL_VERSION {
+		t.Errorf("unexpected host metadata: %+v", entries[0].Metadata)
+	}
+	if entries[1].Reachable || entries[1].Metadata.Title != "old channel" {
+		t.Errorf("unexpected offline entry: %+v", entries[1])
+	}
+}
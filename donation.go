@@ -6,8 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"gonovon/json"
+	"log"
+	"math"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -19,33 +22,42 @@ import (
 var donationRegex = regexp.MustCompile(`donate[0-9]+`)
 var donationMap map[string]string = make(map[string]string)
 
-func ValidateDonation(message *ChatMessage, allowMempool bool) (err error) {
-
-	//Check if message text contains donation, if so validate, otherwise early true nothing to check.
-	var donationSum int
-	donateMatches := donationRegex.FindAllString(message.Text, -1)
+// Donation is the structured donation claim attached to a chat message.
+type Donation struct {
+	Amount string `json:"amount"`
+	Id     string `json:"id"`
+	Hash   string `json:"hash"`
+}
 
-	//No donations always valid
-	if len(donateMatches) == 0 {
-		return nil
-	}
+func ValidateDonation(message *ChatMessage, allowMempool bool) (err error) {
 
-	for _, match := range donateMatches {
-		amount, err := strconv.Atoi(match[6:]) // Remove "donate" from the start
+	//Structured donations take precedence, the text scraping is kept as a legacy fallback.
+	var donationAmount int64
+	var donationId string
+	if message.Donation != nil {
+		donationAmount, err = parseDonationAmount(message.Donation.Amount)
+		if err != nil {
+			return err
+		}
+		if len(message.Donation.Id) != 64 {
+			return errors.New("invalid donation id")
+		}
+		donationId = message.Donation.Id
+		message.Hash = message.Donation.Hash
+	} else {
+		donationAmount, err = legacyDonationAmount(message.Text)
 		if err != nil {
-			fmt.Println("Error parsing amount:", err)
-			continue // Skip to the next match if there's an error
+			return err
 		}
-		donationSum += amount
 	}
 
-	//probably donation0 was included in the message, this is not an actual donation
-	if donationSum == 0 {
+	//No donations always valid
+	if donationAmount == 0 {
 		return nil
 	}
 
 	//donation but no hash always invalid
-	if donationSum > 0 && len(message.Hash) != 64 {
+	if len(message.Hash) != 64 {
 		return errors.New("no tx hash")
 	}
 
@@ -61,45 +73,101 @@ func ValidateDonation(message *ChatMessage, allowMempool bool) (err error) {
 		}
 	}
 
-	if transaction != nil && transaction.Hash == "" {
+	//not found in the mempool, fall back to the chain
+	if transaction == nil {
+		transaction = &json.Transaction{}
+	}
+
+	if transaction.Hash == "" {
 		err = getTransactionWithRetry(context.Background(), message.Hash, transaction)
 		if err != nil {
 			return err
 		}
 	}
 
+	transfer, err := checkDonation(transaction, donationId, donationAmount, srcAddr, client.WalletAddress())
+	if err != nil {
+		return err
+	}
+
+	//Donations are money, write them through instead of waiting for the next flush
+	donationLedger.Append(DonationRecord{
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Src:    message.Src,
+		Amount: common.Fixed64(transfer.Amount).String(),
+		Id:     transaction.Attributes,
+		Hash:   transaction.Hash,
+	})
+	if err := donationLedger.Flush(); err != nil {
+		log.Println("error writing donation ledger", err.Error())
+	}
+
+	return nil
+}
+
+// checkDonation verifies that a transaction pays for an unused donation id, the id is only used up when every check
+// passed so a rejected claim can't burn it.
+func checkDonation(transaction *json.Transaction, donationId string, donationAmount int64, srcAddr string, hostAddr string) (*Transfer, error) {
+	//structured donations have to reference the donation id they were issued
+	if donationId != "" && transaction.Attributes != donationId {
+		return nil, errors.New("transaction does not match donation id")
+	}
+
 	//donation is has to be known
 	hash, exists := donationMap[transaction.Attributes]
 	if !exists {
-		return errors.New("this donation id does not exist")
+		return nil, errors.New("this donation id does not exist")
 	}
 
 	if len(hash) > 0 {
-		return errors.New("this donation was already received")
+		return nil, errors.New("this donation was already received")
 	}
 
-	donationMap[transaction.Attributes] = transaction.Hash
-
 	//incorrect txtype always invalid
 	if transaction.TxType != "TRANSFER_ASSET_TYPE" {
-		return errors.New("incorrect txtype")
+		return nil, errors.New("incorrect txtype")
 	}
 
-	payloadBytes, err := hex.DecodeString(transaction.PayloadData)
+	transfer, err := parseTransfer(transaction)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
-	fmt.Println(payloadBytes)
 
-	transferAsset := new(pb.TransferAsset)
-	err = proto.Unmarshal(payloadBytes, transferAsset)
+	//verify donation amount with transfer amount
+	if donationAmount != transfer.Amount {
+		return nil, errors.New("transfer amount mismatch")
+	}
+
+	//validate transfer sender is the message sender
+	if transfer.Sender != srcAddr {
+		return nil, errors.New("transfer sender is not message src")
+	}
+
+	//validate recipient is this stream host
+	if transfer.Recipient != hostAddr {
+		return nil, errors.New("transfer recipient is not host address")
+	}
+
+	donationMap[transaction.Attributes] = transaction.Hash
+	return transfer, nil
+}
+
+// Transfer is the decoded payload of a TRANSFER_ASSET_TYPE transaction, with wallet addresses.
+type Transfer struct {
+	Sender    string
+	Recipient string
+	Amount    int64
+}
+
+func parseTransfer(transaction *json.Transaction) (*Transfer, error) {
+	payloadBytes, err := hex.DecodeString(transaction.PayloadData)
 	if err != nil {
-		fmt.Println(err)
+		return nil, fmt.Errorf("invalid transfer payload: %w", err)
 	}
 
-	//verify donation amount with transfer amount
-	if int64(donationSum)*int64(nkn.AmountUnit) != transferAsset.Amount {
-		return errors.New("transfer amount mismatch")
+	transferAsset := new(pb.TransferAsset)
+	if err := proto.Unmarshal(payloadBytes, transferAsset); err != nil {
+		return nil, fmt.Errorf("invalid transfer payload: %w", err)
 	}
 
 	programHashSender, _ := common.Uint160ParseFromBytes(transferAsset.Sender)
@@ -107,17 +175,44 @@ func ValidateDonation(message *ChatMessage, allowMempool bool) (err error) {
 	senderAddr, _ := programHashSender.ToAddress()
 	recipientAddr, _ := programHashRecipient.ToAddress()
 
-	//validate transfer sender is the message sender
-	if senderAddr != srcAddr {
-		return errors.New("transfer sender is not message src")
+	return &Transfer{Sender: senderAddr, Recipient: recipientAddr, Amount: transferAsset.Amount}, nil
+}
+
+// parseDonationAmount converts a decimal NKN amount string into the fixed point transfer unit.
+func parseDonationAmount(amount string) (int64, error) {
+	if amount == "" || strings.ContainsAny(amount, "+-") {
+		return 0, errors.New("invalid donation amount")
 	}
 
-	//validate recipient is this stream host
-	if recipientAddr != client.Account().WalletAddress() {
-		return errors.New("transfer recipient is not host address")
+	fixed, err := common.StringToFixed64(amount)
+	if err != nil {
+		return 0, fmt.Errorf("invalid donation amount: %w", err)
+	}
+	if fixed <= 0 {
+		return 0, errors.New("invalid donation amount")
 	}
 
-	return nil
+	return int64(fixed), nil
+}
+
+// legacyDonationAmount sums the whole NKN "donate<n>" claims found in free chat text. Claims that do not fit
+// in an amount, alone or summed, are rejected instead of wrapping around.
+func legacyDonationAmount(text string) (int64, error) {
+	const maxNKN = math.MaxInt64 / int64(nkn.AmountUnit)
+
+	var donationSum int64
+	for _, match := range donationRegex.FindAllString(text, -1) {
+		amount, err := strconv.ParseInt(match[6:], 10, 64) // Remove "donate" from the start
+		if err != nil || amount < 0 || amount > maxNKN {
+			return 0, errors.New("invalid donation amount")
+		}
+		if donationSum > maxNKN-amount {
+			return 0, errors.New("invalid donation amount")
+		}
+		donationSum += amount
+	}
+
+	return donationSum * int64(nkn.AmountUnit), nil
 }
 
 func getTransactionWithRetry(ctx context.Context, hash string, transaction *json.Transaction) (err error) {
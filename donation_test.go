@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"gonovon/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/common"
+)
+
+func TestParseDonationAmount(t *testing.T) {
+	tests := []struct {
+		amount   string
+		expected int64
+		valid    bool
+	}{
+		{"1", 100000000, true},
+		{"0.5", 50000000, true},
+		{"12.00000001", 1200000001, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{"1.000000001", 0, false},
+		{"one", 0, false},
+	}
+	for _, test := range tests {
+		amount, err := parseDonationAmount(test.amount)
+		if (err == nil) != test.valid || amount != test.expected {
+			t.Errorf("%q: expected %v valid %v, got %v %v", test.amount, test.expected, test.valid, amount, err)
+		}
+	}
+}
+
+func TestLegacyDonationAmount(t *testing.T) {
+	maxNKN := math.MaxInt64 / int64(nkn.AmountUnit)
+	tests := []struct {
+		text     string
+		expected int64
+		valid    bool
+	}{
+		{"no donation here", 0, true},
+		{"donate5 thanks", 500000000, true},
+		{"donate2 and donate3", 500000000, true},
+		{fmt.Sprintf("donate%d", maxNKN), maxNKN * int64(nkn.AmountUnit), true},
+		{fmt.Sprintf("donate%d", maxNKN+1), 0, false},
+		{fmt.Sprintf("donate%d donate1", maxNKN), 0, false},
+		{fmt.Sprintf("donate%d donate%d", maxNKN/2+1, maxNKN/2+1), 0, false},
+		{"donate99999999999999999999", 0, false},
+	}
+	for _, test := range tests {
+		amount, err := legacyDonationAmount(test.text)
+		if (err == nil) != test.valid || amount != test.expected {
+			t.Errorf("%q: expected %v valid %v, got %v %v", test.text, test.expected, test.valid, amount, err)
+		}
+	}
+
+	if err := ValidateDonation(&ChatMessage{Text: fmt.Sprintf("donate%d", maxNKN+1)}, false); err == nil {
+		t.Error("expected an overflowing donation to be invalid")
+	}
+}
+
+func TestValidateStructuredDonation(t *testing.T) {
+	id := strings.Repeat("1", 64)
+	hash := strings.Repeat("2", 64)
+	tests := []struct {
+		name     string
+		donation *Donation
+		expected string
+	}{
+		{"invalid amount", &Donation{Amount: "-1", Id: id, Hash: hash}, "invalid donation amount"},
+		{"short id", &Donation{Amount: "1", Id: "1", Hash: hash}, "invalid donation id"},
+		{"no hash", &Donation{Amount: "1", Id: id}, "no tx hash"},
+	}
+	for _, test := range tests {
+		message := &ChatMessage{Text: "thanks", Donation: test.donation}
+		if err := ValidateDonation(message, false); err == nil || err.Error() != test.expected {
+			t.Errorf("%v: expected %q, got %v", test.name, test.expected, err)
+		}
+	}
+
+	if err := ValidateDonation(&ChatMessage{Text: "no donation here"}, false); err != nil {
+		t.Errorf("expected a message without a donation to be valid, got %v", err)
+	}
+}
+
+func TestCheckDonation(t *testing.T) {
+	host := mustWallet(t, strings.Repeat("ab", 32))
+	viewer := mustWallet(t, strings.Repeat("cd", 32))
+
+	amount, _ := common.StringToFixed64("1.5")
+	tx, err := buildTransfer(viewer, host.Address(), amount, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash := tx.Hash()
+	transaction := func(id string, txType string) *json.Transaction {
+		return &json.Transaction{Attributes: id, TxType: txType, Hash: txHash.ToHexString(), PayloadData: hex.EncodeToString(tx.UnsignedTx.Payload.Data)}
+	}
+
+	id := generateDonationEntry()
+	tests := []struct {
+		name        string
+		transaction *json.Transaction
+		donationId  string
+		amount      int64
+		sender      string
+		expected    string
+	}{
+		{"other id", transaction(id, "TRANSFER_ASSET_TYPE"), strings.Repeat("0", 64), int64(amount), viewer.Address(), "transaction does not match donation id"},
+		{"unknown id", transaction(strings.Repeat("0", 64), "TRANSFER_ASSET_TYPE"), "", int64(amount), viewer.Address(), "this donation id does not exist"},
+		{"wrong type", transaction(id, "COINBASE_TYPE"), id, int64(amount), viewer.Address(), "incorrect txtype"},
+		{"wrong amount", transaction(id, "TRANSFER_ASSET_TYPE"), id, int64(amount) + 1, viewer.Address(), "transfer amount mismatch"},
+		{"wrong sender", transaction(id, "TRANSFER_ASSET_TYPE"), id, int64(amount), host.Address(), "transfer sender is not message src"},
+		{"valid", transaction(id, "TRANSFER_ASSET_TYPE"), id, int64(amount), viewer.Address(), ""},
+		{"used twice", transaction(id, "TRANSFER_ASSET_TYPE"), id, int64(amount), viewer.Address(), "this donation was already received"},
+	}
+	for _, test := range tests {
+		transfer, err := checkDonation(test.transaction, test.donationId, test.amount, test.sender, host.Address())
+		if test.expected == "" {
+			if err != nil || transfer.Amount != int64(amount) {
+				t.Errorf("%v: expected the donation to be valid, got %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%v: expected %q, got %v", test.name, test.expected, err)
+		}
+	}
+	if donationMap[id] != txHash.ToHexString() {
+		t.Error("expected the valid donation to use up its id")
+	}
+
+	//A rejected claim keeps the id for the real transfer
+	other := generateDonationEntry()
+	if _, err := checkDonation(transaction(other, "TRANSFER_ASSET_TYPE"), other, int64(amount), host.Address(), host.Address()); err == nil {
+		t.Fatal("expected a transfer from someone else to be rejected")
+	}
+	if _, err := checkDonation(transaction(other, "TRANSFER_ASSET_TYPE"), other, int64(amount), viewer.Address(), host.Address()); err != nil {
+		t.Errorf("expected the id to still be usable after a rejected claim, got %v", err)
+	}
+}
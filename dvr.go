@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var dvr *DVR
+
+// dvrSegmentName matches the <segment id>_<quality>.ts files of segmentPath.
+var dvrSegmentName = regexp.MustCompile(`^[0-9]+_[0-9]+\.ts$`)
+
+// DVR keeps the segments of the last minutes of every quality level, so viewers can rewind or fill gaps.
+type DVR struct {
+	window  time.Duration
+	path    string
+	entries []*dvrEntry
+	mutex   sync.RWMutex
+}
+
+// dvrEntry is one segment in all of its quality levels, levels are nil when stored on disk.
+type dvrEntry struct {
+	id         int
+	time       time.Time
+	levels     [][]byte
+	levelCount int
+}
+
+// DvrWindow is the range of segment ids viewers can request.
+type DvrWindow struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+// NewDVR creates a DVR from the configuration, returns nil when the DVR is disabled.
+func NewDVR(config *Config) *DVR {
+	if config.DvrMinutes <= 0 {
+		return nil
+	}
+
+	path := config.DvrPath
+	if path != "" {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			log.Println("error creating dvr directory, keeping dvr in memory", err.Error())
+			path = ""
+		} else {
+			//Segments of a previous run can not be requested anymore
+			removeDvrSegments(path)
+		}
+	}
+
+	return &DVR{
+		window: time.Duration(config.DvrMinutes) * time.Minute,
+		path:   path,
+	}
+}
+
+// removeDvrSegments removes the segment files a DVR left in its directory, anything else in it is kept.
+func removeDvrSegments(path string) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		log.Println("error reading dvr directory", err.Error())
+		return
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && dvrSegmentName.MatchString(entry.Name()) {
+			os.Remove(filepath.Join(path, entry.Name()))
+		}
+	}
+}
+
+// Add stores the chunks of every quality level of a segment and evicts segments outside of the window.
+func (d *DVR) Add(segmentId int, transcodedChunksArray [][][]byte) {
+	if d == nil {
+		return
+	}
+
+	entry := &dvrEntry{
+		id:         segmentId,
+		time:       time.Now(),
+		levelCount: len(transcodedChunksArray),
+	}
+
+	levels := make([][]byte, len(transcodedChunksArray))
+	for q, chunks := range transcodedChunksArray {
+		levels[q] = joinChunks(chunks)
+	}
+
+	if d.path == "" {
+		entry.levels = levels
+	} else {
+		for q, level := range levels {
+			if err := os.WriteFile(d.segmentPath(segmentId, q), level, 0644); err != nil {
+				log.Println("error writing dvr segment", err.Error())
+				return
+			}
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	//Segments can arrive out of order, keep the entries sorted by id and replace a segment that is added again
+	i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].id >= segmentId })
+	if i < len(d.entries) && d.entries[i].id == segmentId {
+		d.entries[i] = entry
+	} else {
+		d.entries = slices.Insert(d.entries, i, entry)
+	}
+
+	kept := d.entries[:0]
+	for _, e := range d.entries {
+		if time.Since(e.time) <= d.window {
+			kept = append(kept, e)
+			continue
+		}
+		if d.path != "" {
+			for q := 0; q < e.levelCount; q++ {
+				os.Remove(d.segmentPath(e.id, q))
+			}
+		}
+	}
+	clear(d.entries[len(kept):])
+	d.entries = kept
+}
+
+// Get returns the chunks of a segment in the requested quality level, or the closest available one.
+func (d *DVR) Get(segmentId int, quality int) ([][]byte, error) {
+	if d == nil {
+		return nil, fmt.Errorf("dvr is disabled")
+	}
+
+	d.mutex.RLock()
+	var entry *dvrEntry
+	for _, e := range d.entries {
+		if e.id == segmentId {
+			entry = e
+			break
+		}
+	}
+	d.mutex.RUnlock()
+
+	if entry == nil {
+		return nil, fmt.Errorf("segment %v is not in the dvr window", segmentId)
+	}
+
+	quality = max(0, min(quality, entry.levelCount-1))
+
+	var segment []byte
+	if entry.levels != nil {
+		segment = entry.levels[quality]
+	} else {
+		var err error
+		segment, err = os.ReadFile(d.segmentPath(segmentId, quality))
+		if err != nil {
+			return nil, fmt.Errorf("segment %v is not in the dvr window", segmentId)
+		}
+	}
+
+	return ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, segmentId), nil
+}
+
+// Segments returns the segments of a quality level that were added after since, in segment id order. Segments
+// outside of the window are left out even if no Add evicted them yet.
+func (d *DVR) Segments(since time.Time, quality int) ([][]byte, error) {
+	if d == nil {
+		return nil, fmt.Errorf("dvr is disabled")
+	}
+
+	if start := time.Now().Add(-d.window); since.Before(start) {
+		since = start
+	}
+
+	d.mutex.RLock()
+	ids := make([]int, 0)
+	for _, e := range d.entries {
+		if e.time.After(since) {
+			ids = append(ids, e.id)
+		}
+	}
+	d.mutex.RUnlock()
+
+	segments := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		chunks, err := d.Get(id, quality)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, joinChunks(chunks))
+	}
+
+	return segments, nil
+}
+
+// Window returns the range of segments currently in the DVR, or nil if it is empty.
+func (d *DVR) Window() *DvrWindow {
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if len(d.entries) == 0 {
+		return nil
+	}
+
+	return &DvrWindow{
+		First: d.entries[0].id,
+		Last:  d.entries[len(d.entries)-1].id,
+	}
+}
+
+func (d *DVR) segmentPath(segmentId int, quality int) string {
+	return filepath.Join(d.path, strconv.Itoa(segmentId)+"_"+strconv.Itoa(quality)+".ts")
+}
+
+// joinChunks strips the metadata prefix of the chunks and rebuilds the segment.
+func joinChunks(chunks [][]byte) []byte {
+	var buffer bytes.Buffer
+	for _, chunk := range chunks {
+		buffer.Write(chunk[3*4:])
+	}
+	return buffer.Bytes()
+}
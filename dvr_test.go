@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewDVRKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"12_0.ts", "12_1.ts", "clip.ts", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "2024-05-01_20-00-00"), 0755)
+
+	if NewDVR(&Config{DvrMinutes: 1, DvrPath: dir}) == nil {
+		t.Fatal("expected a dvr")
+	}
+
+	for name, kept := range map[string]bool{"12_0.ts": false, "12_1.ts": false, "clip.ts": true, "notes.txt": true, "2024-05-01_20-00-00": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%v: expected kept %v", name, kept)
+		}
+	}
+}
+
+// dvrLevels returns the chunks of a segment in two quality levels, "<segment>-0" and "<segment>-1".
+func dvrLevels(segment string) [][][]byte {
+	return [][][]byte{
+		ChunkByByteSizeWithMetadata([]byte(segment+"-0"), CHUNK_SIZE, 0),
+		ChunkByByteSizeWithMetadata([]byte(segment+"-1"), CHUNK_SIZE, 0),
+	}
+}
+
+func TestDVRAddAndGet(t *testing.T) {
+	for name, path := range map[string]string{"memory": "", "disk": t.TempDir()} {
+		d := NewDVR(&Config{DvrMinutes: 1, DvrPath: path})
+		d.Add(7, dvrLevels("seven"))
+
+		for quality, expected := range map[int]string{0: "seven-0", 1: "seven-1", 5: "seven-1", -1: "seven-0"} {
+			chunks, err := d.Get(7, quality)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			if !bytes.Equal(joinChunks(chunks), []byte(expected)) {
+				t.Errorf("%v: quality %v: expected %v, got %q", name, quality, expected, joinChunks(chunks))
+			}
+			if id := binary.LittleEndian.Uint32(chunks[0][:4]); id != 7 {
+				t.Errorf("%v: expected the chunks of segment 7, got %v", name, id)
+			}
+		}
+
+		if _, err := d.Get(8, 0); err == nil {
+			t.Errorf("%v: expected an error for a segment that was never added", name)
+		}
+	}
+}
+
+func TestDVRWindowEviction(t *testing.T) {
+	path := t.TempDir()
+	d := NewDVR(&Config{DvrMinutes: 1, DvrPath: path})
+	d.window = 50 * time.Millisecond
+
+	d.Add(1, dvrLevels("one"))
+	d.Add(2, dvrLevels("two"))
+	time.Sleep(100 * time.Millisecond)
+	d.Add(3, dvrLevels("three"))
+
+	if window := d.Window(); window == nil || window.First != 3 || window.Last != 3 {
+		t.Errorf("expected only segment 3 in the window, got %+v", window)
+	}
+	for _, id := range []int{1, 2} {
+		if _, err := d.Get(id, 0); err == nil {
+			t.Errorf("expected segment %v to be evicted", id)
+		}
+	}
+	for _, name := range []string{"1_0.ts", "1_1.ts", "2_0.ts", "2_1.ts"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+			t.Errorf("expected %v to be removed", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(path, "3_1.ts")); err != nil {
+		t.Errorf("expected the segment in the window to be kept, %v", err)
+	}
+}
+
+func TestDVROutOfOrderAdds(t *testing.T) {
+	d := NewDVR(&Config{DvrMinutes: 1})
+	for _, id := range []int{5, 3, 6, 4, 3} {
+		d.Add(id, dvrLevels(string(rune('a'+id))))
+	}
+
+	if window := d.Window(); window == nil || window.First != 3 || window.Last != 6 {
+		t.Errorf("expected a window of 3 to 6, got %+v", window)
+	}
+
+	segments, err := d.Segments(time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined := bytes.Join(segments, []byte(" ")); string(joined) != "d-0 e-0 f-0 g-0" {
+		t.Errorf("expected the segments once in id order, got %q", joined)
+	}
+}
@@ -29,7 +29,7 @@ require (
 )
 
 require (
-	github.com/fsnotify/fsnotify v1.7.0 // indirect
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/golang/protobuf v1.5.2
 	github.com/gorilla/websocket v1.5.1 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
@@ -39,7 +39,7 @@ require (
 	github.com/nknorg/ncp-go v1.0.5 // indirect
 	github.com/nknorg/nkn v1.1.7-beta
 	github.com/nknorg/nkn-sdk-go v1.4.7
-	github.com/nknorg/nkn/v2 v2.2.0 // indirect
+	github.com/nknorg/nkn/v2 v2.2.0
 	github.com/nknorg/nkngomobile v0.0.0-20220615081414-671ad1afdfa9
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
 	github.com/pbnjay/memory v0.0.0-20190104145345-974d429e7ae4 // indirect
@@ -49,7 +49,10 @@ require (
 	google.golang.org/protobuf v1.33.0 // indirect
 )
 
-require github.com/bluenviron/mediamtx v1.7.0
+require (
+	github.com/bluenviron/mediamtx v1.7.0
+	golang.org/x/term v0.19.0
+)
 
 require (
 	github.com/MicahParks/jwkset v0.5.17 // indirect
@@ -107,7 +110,6 @@ require (
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/term v0.19.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
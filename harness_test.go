@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gonovon/transport"
+	"gonovon/viewer"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+// The host under test runs on a loopback network, with ffmpeg and ffprobe replaced by canned outputs.
+var network *transport.LoopbackNetwork
+var hostTransport *recordingTransport
+var fakeMedia *fakeCommands
+
+func TestMain(m *testing.M) {
+	fakeMedia = &fakeCommands{}
+	commands = fakeMedia
+
+	network = transport.NewLoopbackNetwork()
+	loopback, err := network.NewTransport()
+	if err != nil {
+		panic(err)
+	}
+	hostTransport = &recordingTransport{Loopback: loopback}
+	client = hostTransport
+	sender = NewSendScheduler(client)
+	sender.Start()
+
+	viewers = NewViewers(30 * time.Second)
+	reruns = NewReruns()
+	resetHost()
+
+	receiveMessages()
+
+	os.Exit(m.Run())
+}
+
+// resetHost brings the host back to its state before the first segment.
+func resetHost() {
+	lastRtmpSegment.Store(0)
+	segmentId.Store(0)
+	lastSegment.Store(nil)
+	streamSource.Store(nil)
+	hostConfig.Store(&Config{Title: "test"})
+
+	//Goroutines of the previous test can still hold the viewers, empty them instead of replacing them
+	viewers.mutex.Lock()
+	viewers.messages = make(map[string]*messageData)
+	viewers.viewerQuality = make(map[string]int)
+	viewers.SetAddresses()
+	viewers.mutex.Unlock()
+
+	hostTransport.reset()
+}
+
+// sentPayload is a payload the host sent, with its destinations.
+type sentPayload struct {
+	dests   []string
+	payload *payloads.Payload
+}
+
+// recordingTransport records every payload the host sends before delivering it on the loopback network.
+type recordingTransport struct {
+	*transport.Loopback
+
+	sent  []sentPayload
+	mutex sync.Mutex
+}
+
+func (t *recordingTransport) SendPayload(dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	t.mutex.Lock()
+	t.sent = append(t.sent, sentPayload{dests: dests.Elems(), payload: payload})
+	t.mutex.Unlock()
+
+	return t.Loopback.SendPayload(dests, payload, config)
+}
+
+func (t *recordingTransport) SendPayloadVia(subClient int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	return t.SendPayload(dests, payload, config)
+}
+
+func (t *recordingTransport) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sent = nil
+}
+
+// binaryPayloads returns the sent binary payloads that are not replies.
+func (t *recordingTransport) binaryPayloads() []sentPayload {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	sent := make([]sentPayload, 0)
+	for _, s := range t.sent {
+		if s.payload.Type == payloads.PayloadType_BINARY && len(s.payload.ReplyToId) == 0 {
+			sent = append(sent, s)
+		}
+	}
+	return sent
+}
+
+// fakeCommands answers ffprobe with a 1080p30 h264 stream, transcodes by filling half the input size with
+// the target resolution so every quality level is recognizable, and remuxes by copying the input.
+type fakeCommands struct {
+	calls []string
+	mutex sync.Mutex
+}
+
+const fakeProbeOutput = `{"streams":[{"codec_type":"audio","codec_name":"aac"},{"codec_type":"video","codec_name":"h264","width":1920,"height":1080,"r_frame_rate":"30/1"}],"format":{}}`
+
+// fakeAudioSegment probes as a stream without video.
+var fakeAudioSegment = []byte("audio only")
+
+const fakeAudioProbeOutput = `{"streams":[{"codec_type":"audio","codec_name":"aac"}],"format":{}}`
+
+func (f *fakeCommands) Run(name string, args []string, stdin []byte) ([]byte, []byte, error) {
+	f.mutex.Lock()
+	f.calls = append(f.calls, name+" "+strings.Join(args, " "))
+	f.mutex.Unlock()
+
+	if name == "ffprobe" {
+		if bytes.Equal(stdin, fakeAudioSegment) {
+			return []byte(fakeAudioProbeOutput), nil, nil
+		}
+		return []byte(fakeProbeOutput), nil, nil
+	}
+
+	for i, arg := range args {
+		if arg == "image2pipe" {
+			return []byte("thumbnail"), nil, nil
+		}
+		if arg == "mp4" {
+			//Remuxing copies the input to the output file
+			return nil, nil, os.WriteFile(args[len(args)-1], stdin, 0644)
+		}
+		if arg == "-filter:v" {
+			resolution, _ := strconv.Atoi(strings.TrimPrefix(strings.Split(args[i+1], ",")[0], "scale=-2:"))
+			return fakeTranscode(stdin, resolution), nil, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// Start pretends to be an ffmpeg pulling a source, it writes fakeSourceSegments copies of the test segment and
+// their list, then keeps running until killed.
+func (f *fakeCommands) Start(name string, args []string) (func(), <-chan error, error) {
+	f.mutex.Lock()
+	f.calls = append(f.calls, name+" "+strings.Join(args, " "))
+	f.mutex.Unlock()
+
+	segment, err := os.ReadFile("testdata/segment.ts")
+	if err != nil {
+		return nil, nil, err
+	}
+	listFile, output := args[slices.Index(args, "-segment_list")+1], args[len(args)-1]
+
+	list := ""
+	for i := 0; i < fakeSourceSegments; i++ {
+		name := fmt.Sprintf(output, i)
+		if err := os.WriteFile(name, segment, 0644); err != nil {
+			return nil, nil, err
+		}
+		list += filepath.Base(name) + "\n"
+	}
+	if err := os.WriteFile(listFile, []byte(list), 0644); err != nil {
+		return nil, nil, err
+	}
+
+	killed := make(chan struct{})
+	exited := make(chan error, 1)
+	go func() {
+		<-killed
+		exited <- errors.New("killed")
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(killed) }) }, exited, nil
+}
+
+const fakeSourceSegments = 3
+
+func fakeTranscode(segment []byte, resolution int) []byte {
+	return bytes.Repeat([]byte{byte(resolution)}, len(segment)/2)
+}
+
+func loadFixture(t *testing.T) []byte {
+	t.Helper()
+
+	segment, err := os.ReadFile("testdata/segment.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segment
+}
+
+// newTestViewer joins a viewer on the loopback network, the host has to be broadcasting.
+func newTestViewer(t *testing.T, quality int) *viewer.Viewer {
+	t.Helper()
+
+	viewerTransport, err := network.NewTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := viewer.New(viewerTransport, client.Address(), &viewer.Config{Quality: quality, RequestTimeout: 2 * time.Second})
+	if err := v.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		v.Close()
+		viewerTransport.Close()
+	})
+	return v
+}
+
+// nextSegment waits for the next complete segment of a viewer.
+func nextSegment(t *testing.T, v *viewer.Viewer) *viewer.Segment {
+	t.Helper()
+
+	select {
+	case segment := <-v.Segments:
+		return segment
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for segment")
+		return nil
+	}
+}
+
+// waitFor polls until condition holds.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func mustTransport(t *testing.T) *transport.Loopback {
+	t.Helper()
+
+	loopback, err := network.NewTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loopback.Close() })
+	return loopback
+}
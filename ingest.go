@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// INGEST_PROTOCOLS are the protocols encoders can publish with, they map to the MediaMTX setting of the same name.
+var INGEST_PROTOCOLS = []string{"rtmp", "srt", "webrtc"}
+var DEFAULT_INGEST = []string{"rtmp"}
+
+// SRT passphrases are 10 to 79 characters.
+const MIN_SRT_PASSPHRASE = 10
+const MAX_SRT_PASSPHRASE = 79
+
+// ingestProtocols returns the configured ingest protocols, RTMP when none are configured.
+func ingestProtocols(config *Config) []string {
+	if len(config.Ingest) == 0 {
+		return DEFAULT_INGEST
+	}
+	return config.Ingest
+}
+
+// validateIngest checks the ingest protocols and the SRT passphrase.
+func validateIngest(c *Config) []error {
+	problems := make([]error, 0)
+	for i, protocol := range c.Ingest {
+		if !slices.Contains(INGEST_PROTOCOLS, protocol) {
+			problems = append(problems, fmt.Errorf("ingest[%v]: %q is not one of %v", i, protocol, strings.Join(INGEST_PROTOCOLS, ", ")))
+		}
+	}
+	for _, duplicate := range duplicates(c.Ingest) {
+		problems = append(problems, fmt.Errorf("ingest: %v is listed more than once", duplicate))
+	}
+
+	if slices.Contains(c.Ingest, "srt") && c.SrtPassphrase == "" {
+		problems = append(problems, errors.New("srtPassphrase: required for srt ingest"))
+	}
+	if c.SrtPassphrase != "" && (len(c.SrtPassphrase) < MIN_SRT_PASSPHRASE || len(c.SrtPassphrase) > MAX_SRT_PASSPHRASE) {
+		problems = append(problems, fmt.Errorf("srtPassphrase: must be %v to %v characters", MIN_SRT_PASSPHRASE, MAX_SRT_PASSPHRASE))
+	}
+	return problems
+}
+
+// setIngest enables the configured ingest protocols in a mediamtx.yml and sets the SRT passphrase, only the lines
+// of those settings are changed.
+func setIngest(yml []byte, config *Config) ([]byte, error) {
+	protocols := ingestProtocols(config)
+	for _, protocol := range INGEST_PROTOCOLS {
+		value := "no"
+		if slices.Contains(protocols, protocol) {
+			value = "yes"
+		}
+		var err error
+		if yml, err = setYAMLValue(yml, "", protocol, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return setYAMLValue(yml, "  ", "srtPublishPassphrase", strconv.Quote(config.SrtPassphrase))
+}
+
+// setYAMLValue replaces the value of the first key with the given indentation, keeping the rest of the file and its
+// comments as they are.
+func setYAMLValue(yml []byte, indent string, key string, value string) ([]byte, error) {
+	line := regexp.MustCompile(`(?m)^` + indent + regexp.QuoteMeta(key) + `:.*$`)
+	location := line.FindIndex(yml)
+	if location == nil {
+		return nil, fmt.Errorf("%v is not set in the MediaMTX config", key)
+	}
+
+	updated := make([]byte, 0, len(yml)+len(value))
+	updated = append(updated, yml[:location[0]]...)
+	updated = append(updated, indent+key+": "+value...)
+	return append(updated, yml[location[1]:]...), nil
+}
+
+// applyIngest writes the ingest settings of config.json into the MediaMTX config file, before MediaMTX reads it.
+func applyIngest(ymlFile string, config *Config) error {
+	info, err := os.Stat(ymlFile)
+	if err != nil {
+		return err
+	}
+	yml, err := os.ReadFile(ymlFile)
+	if err != nil {
+		return err
+	}
+	updated, err := setIngest(yml, config)
+	if err != nil {
+		return fmt.Errorf("%v: %w", ymlFile, err)
+	}
+	if string(updated) == string(yml) {
+		return nil
+	}
+
+	//The SRT passphrase is a secret
+	mode := info.Mode().Perm()
+	if config.SrtPassphrase != "" {
+		mode &= 0600
+	}
+
+	tmp := ymlFile + ".tmp"
+	if err := os.WriteFile(tmp, updated, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ymlFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
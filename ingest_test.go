@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetIngestEnablesProtocols(t *testing.T) {
+	cfg := &Config{Ingest: []string{"srt", "webrtc"}, SrtPassphrase: "field streamers"}
+	yml, err := setIngest([]byte(mediaMTXDefaults), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"\nrtmp: no\n", "\nsrt: yes\n", "\nwebrtc: yes\n", "\n  srtPublishPassphrase: \"field streamers\"\n"} {
+		if !strings.Contains(string(yml), line) {
+			t.Errorf("expected %q in the MediaMTX config", strings.TrimSpace(line))
+		}
+	}
+	if !strings.Contains(string(yml), "\nsrtAddress: :8890\n") {
+		t.Error("expected the other settings to stay as they are")
+	}
+
+	yml, _ = setIngest(yml, &Config{})
+	if !strings.Contains(string(yml), "\nrtmp: yes\n") || !strings.Contains(string(yml), "\nsrt: no\n") ||
+		!strings.Contains(string(yml), "\n  srtPublishPassphrase: \"\"\n") {
+		t.Error("expected only rtmp without ingest settings")
+	}
+}
+
+func TestValidateIngest(t *testing.T) {
+	problems := validateIngest(&Config{Ingest: []string{"srt", "whip", "srt"}, SrtPassphrase: "short"})
+	expected := []string{"ingest[1]", "ingest: srt is listed more than once", "srtPassphrase: must be"}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %v problems, got %v", len(expected), problems)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(problems[i].Error(), e) {
+			t.Errorf("expected a problem starting with %q, got %v", e, problems[i])
+		}
+	}
+
+	if problems := validateIngest(&Config{Ingest: []string{"srt"}}); len(problems) != 1 {
+		t.Errorf("expected srt without a passphrase to be a problem, got %v", problems)
+	}
+}
+
+func TestParseSourceInfo(t *testing.T) {
+	codec, resolution, framerate, err := parseSourceInfo(map[string]string{"codec": "h264", "resolution": "1280x720", "framerate": "30000/1001"})
+	if err != nil || codec != "h264" || resolution != 720 || framerate != 30 {
+		t.Errorf("unexpected source info: %v %v %v %v", codec, resolution, framerate, err)
+	}
+
+	//Audio only, like a WHIP publisher whose video codec can't be muxed
+	if _, _, _, err := parseSourceInfo(map[string]string{}); err == nil {
+		t.Error("expected a segment without video to be refused")
+	}
+	if _, _, _, err := parseSourceInfo(map[string]string{"codec": "h264", "resolution": "1280x720", "framerate": "0/0"}); err == nil {
+		t.Error("expected an unknown framerate to be refused")
+	}
+}
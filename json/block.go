@@ -0,0 +1,11 @@
+package json
+
+// Block is the answer of the getblock RPC call, only the fields gonovon reads.
+type Block struct {
+	Header struct {
+		Height    uint32 `json:"height"`
+		Timestamp int64  `json:"timestamp"`
+	} `json:"header"`
+	Transactions []Transaction `json:"transactions"`
+	Hash         string        `json:"hash"`
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const DEFAULT_DONATION_LEDGER = "./donations.jsonl"
+
+var chatLog *JSONLog
+var donationLedger *JSONLog
+
+// JSONLog appends JSON lines to a file through a buffer, the file is only created on the first entry.
+type JSONLog struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	mutex  sync.Mutex
+}
+
+// DonationRecord is an entry of the donation ledger, written once a donation is validated.
+type DonationRecord struct {
+	Time   string `json:"time"`
+	Src    string `json:"src"`
+	Amount string `json:"amount"`
+	Id     string `json:"id"`
+	Hash   string `json:"hash"`
+}
+
+// NewJSONLog returns nil when path is empty, all methods are no-ops on nil.
+func NewJSONLog(path string) *JSONLog {
+	if path == "" {
+		return nil
+	}
+	return &JSONLog{path: path}
+}
+
+// NewDonationLedger opens the donation ledger configured in config.json, or the default ledger.
+func NewDonationLedger(config *Config) *JSONLog {
+	return NewJSONLog(donationLedgerPath(config))
+}
+
+func donationLedgerPath(config *Config) string {
+	if config.DonationLedger == "" {
+		return DEFAULT_DONATION_LEDGER
+	}
+	return config.DonationLedger
+}
+
+// readDonationLedger reads every record of the donation ledger, a missing ledger has no records.
+func readDonationLedger(path string) ([]DonationRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make([]DonationRecord, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record DonationRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%v line %v: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Append buffers an entry, it reaches the file on Flush or when the buffer is full.
+func (l *JSONLog) Append(entry interface{}) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("error encoding log entry", err.Error())
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.writer == nil {
+		if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+			log.Println("error creating log directory", err.Error())
+			return
+		}
+		l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Println("error opening log", l.path, err.Error())
+			return
+		}
+		l.writer = bufio.NewWriter(l.file)
+	}
+
+	l.writer.Write(append(data, '\n'))
+}
+
+// Flush writes the buffered entries and syncs the file to disk.
+func (l *JSONLog) Flush() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.writer == nil {
+		return nil
+	}
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *JSONLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	if err := l.Flush(); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	l.writer = nil
+	return err
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"golang.org/x/term"
+)
+
+const DEFAULT_KEYSTORE = "wallet.json"
+
+// keystorePassphrase is the passphrase once it is read, the environment variable is removed and a terminal user is
+// only asked once when a new keystore is created and unlocked right after.
+var keystorePassphrase string
+
+var errNoPassphrase = errors.New("no keystore passphrase, set GONOVON_KEYSTORE_PASSPHRASE or GONOVON_KEYSTORE_PASSPHRASE_FILE, or start gonovon in a terminal")
+
+// keystorePath resolves a relative keystore path against the directory of the config file.
+func keystorePath(configFile string, keystore string) string {
+	if keystore == "" || filepath.IsAbs(keystore) {
+		return keystore
+	}
+	return filepath.Join(filepath.Dir(configFile), keystore)
+}
+
+// readPassphrase reads the keystore passphrase from GONOVON_KEYSTORE_PASSPHRASE, the file in
+// GONOVON_KEYSTORE_PASSPHRASE_FILE, or a terminal prompt. A new passphrase is asked for twice.
+func readPassphrase(confirm bool) (string, error) {
+	if keystorePassphrase != "" {
+		return keystorePassphrase, nil
+	}
+
+	passphrase, err := lookupPassphrase(confirm)
+	if err != nil {
+		return "", err
+	}
+	keystorePassphrase = passphrase
+	return passphrase, nil
+}
+
+func lookupPassphrase(confirm bool) (string, error) {
+	if passphrase, ok := os.LookupEnv(ENV_PREFIX + "KEYSTORE_PASSPHRASE"); ok {
+		//Commands started later, like ffmpeg, don't need it
+		os.Unsetenv(ENV_PREFIX + "KEYSTORE_PASSPHRASE")
+		return passphrase, nil
+	}
+	if file, ok := os.LookupEnv(ENV_PREFIX + "KEYSTORE_PASSPHRASE_FILE"); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("keystore passphrase file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errNoPassphrase
+	}
+
+	fmt.Fprint(os.Stderr, "Keystore passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("the keystore passphrase can not be empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+		repeated, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(passphrase, repeated) {
+			return "", errors.New("the passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+// createKeystore encrypts the seed with the passphrase into a new keystore file, it never overwrites an existing one.
+func createKeystore(path string, seed []byte, passphrase string) (*nkn.Wallet, error) {
+	if passphrase == "" {
+		return nil, errors.New("the keystore passphrase can not be empty")
+	}
+
+	account, err := nkn.NewAccount(seed)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := nkn.NewWallet(account, &nkn.WalletConfig{Password: passphrase})
+	if err != nil {
+		return nil, err
+	}
+	data, err := wallet.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating keystore: %w", err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("error writing keystore: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("error writing keystore: %w", err)
+	}
+	return wallet, nil
+}
+
+// createDefaultKeystore creates the keystore of a new config with a new wallet, an existing keystore is kept.
+func createDefaultKeystore(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		fmt.Println("Using the existing keystore", path)
+		return nil
+	}
+
+	fmt.Println("Creating a new wallet, choose the passphrase that encrypts it")
+	passphrase, err := readPassphrase(true)
+	if err != nil {
+		return err
+	}
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		return err
+	}
+	wallet, err := createKeystore(path, account.Seed(), passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created wallet", wallet.Address(), "in", path, "back up the keystore and its passphrase")
+	return nil
+}
+
+// openKeystore decrypts the seed in a keystore file.
+func openKeystore(path string, passphrase string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keystore: %w", err)
+	}
+	wallet, err := nkn.WalletFromJSON(string(data), &nkn.WalletConfig{Password: passphrase})
+	if err != nil {
+		return nil, fmt.Errorf("could not open keystore %v, wrong passphrase? %w", path, err)
+	}
+	return wallet.Seed(), nil
+}
+
+// unlockKeystore sets the seed from the keystore, unless it is already set by config.json, the environment or a
+// seed file.
+func unlockKeystore(configFile string, cfg *Config) error {
+	if cfg.Seed != "" || cfg.Keystore == "" {
+		return nil
+	}
+
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return err
+	}
+	seed, err := openKeystore(keystorePath(configFile, cfg.Keystore), passphrase)
+	if err != nil {
+		//A wrong passphrase is not kept
+		keystorePassphrase = ""
+		return err
+	}
+	cfg.Seed = hex.EncodeToString(seed)
+	return nil
+}
+
+// migrateSeed moves the plaintext seed of a config file into a new keystore and removes it from the config file.
+func migrateSeed(configFile string, passphrase string) (*nkn.Wallet, string, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, "", err
+	}
+	//Rewriting the file drops what gonovon doesn't know, so it has to be clean first
+	if problems := checkConfigFields(data); len(problems) > 0 {
+		return nil, "", fmt.Errorf("fix %v first, run gonovon config check: %w", configFile, errors.Join(problems...))
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, "", err
+	}
+	if cfg.Seed == "" {
+		return nil, "", fmt.Errorf("%v holds no plaintext seed", configFile)
+	}
+	seed, err := hex.DecodeString(cfg.Seed)
+	if err != nil || len(seed) != 32 {
+		return nil, "", errors.New("the seed in config.json is not 64 hex characters")
+	}
+
+	if cfg.Keystore == "" {
+		cfg.Keystore = DEFAULT_KEYSTORE
+	}
+	path := keystorePath(configFile, cfg.Keystore)
+	wallet, err := createKeystore(path, seed, passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//Only strip the seed once the keystore is known to open
+	if opened, err := openKeystore(path, passphrase); err != nil || !bytes.Equal(opened, seed) {
+		os.Remove(path)
+		return nil, "", errors.New("the new keystore could not be verified, config.json is unchanged")
+	}
+
+	cfg.Seed = ""
+	data, err = json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	tmp := configFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, "", fmt.Errorf("error writing config file, the seed is in both %v and %v: %w", configFile, path, err)
+	}
+	if err := os.Rename(tmp, configFile); err != nil {
+		os.Remove(tmp)
+		return nil, "", fmt.Errorf("error writing config file, the seed is in both %v and %v: %w", configFile, path, err)
+	}
+	return wallet, path, nil
+}
+
+// runKeystore manages the encrypted wallet seed: gonovon keystore migrate [file]
+func runKeystore(args []string) {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprintln(os.Stderr, "usage: gonovon keystore migrate [file]")
+		os.Exit(2)
+	}
+
+	configFile := envOr("CONFIG", "./config.json")
+	if len(args) > 1 {
+		configFile = args[1]
+	}
+
+	passphrase, err := readPassphrase(true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	wallet, path, err := migrateSeed(configFile, passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Moved the seed of", wallet.Address(), "to", path)
+	fmt.Println("Back up the keystore and its passphrase, the wallet can not be recovered without both.")
+	fmt.Println("Copies of the old", configFile, "still hold the plaintext seed, delete them.")
+}
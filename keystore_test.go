@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateSeedMovesSeedToKeystore(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	seed := strings.Repeat("ab", 32)
+	os.WriteFile(configFile, []byte(`{"seed": "`+seed+`", "title": "test", "owner": "", "transcoders": ["720p"]}`), 0644)
+
+	if _, _, err := migrateSeed(configFile, ""); err == nil {
+		t.Error("expected an empty passphrase to be refused")
+	}
+
+	_, path, err := migrateSeed(configFile, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, DEFAULT_KEYSTORE) {
+		t.Errorf("unexpected keystore path %v", path)
+	}
+
+	for _, file := range []string{configFile, path} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected %v to be 0600, got %v", file, info.Mode().Perm())
+		}
+	}
+
+	data, _ := os.ReadFile(configFile)
+	if strings.Contains(string(data), seed) {
+		t.Error("expected the seed to be removed from config.json")
+	}
+	var cfg Config
+	json.Unmarshal(data, &cfg)
+	if cfg.Keystore != DEFAULT_KEYSTORE || cfg.Title != "test" || len(cfg.Transcoders) != 1 {
+		t.Errorf("unexpected config after migrating: %+v", cfg)
+	}
+
+	if _, err := openKeystore(path, "wrong"); err == nil {
+		t.Error("expected a wrong passphrase to fail")
+	}
+
+	keystorePassphrase = ""
+	t.Setenv("GONOVON_KEYSTORE_PASSPHRASE", "hunter2")
+	if err := unlockKeystore(configFile, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Seed != seed {
+		t.Errorf("expected the keystore to unlock seed %v, got %v", seed, cfg.Seed)
+	}
+	if _, ok := os.LookupEnv("GONOVON_KEYSTORE_PASSPHRASE"); ok {
+		t.Error("expected the passphrase to be removed from the environment")
+	}
+
+	if _, _, err := migrateSeed(configFile, "hunter2"); err == nil {
+		t.Error("expected migrating a config without a plaintext seed to fail")
+	}
+}
+
+func TestFirstStartCreatesAndUnlocksKeystore(t *testing.T) {
+	//NewConfig writes mediamtx.yml to the working directory
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	keystorePassphrase = ""
+	t.Cleanup(func() { keystorePassphrase = "" })
+	t.Setenv("GONOVON_KEYSTORE_PASSPHRASE", "hunter2")
+
+	cfg, err := NewConfig(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Keystore != DEFAULT_KEYSTORE || len(cfg.Seed) != 64 {
+		t.Errorf("expected the new keystore to be unlocked, got %+v", cfg)
+	}
+	seed, err := openKeystore(filepath.Join(dir, DEFAULT_KEYSTORE), "hunter2")
+	if err != nil || hex.EncodeToString(seed) != cfg.Seed {
+		t.Errorf("expected the keystore to hold the unlocked seed, got %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gonovon/transport"
+	"gonovon/viewer"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+// loadTest runs a host and synthetic viewers on a loopback network and measures how the host holds up.
+type loadTest struct {
+	network *transport.LoopbackNetwork
+	host    *timedTransport
+
+	viewers    []*viewer.Viewer
+	transports []*transport.Loopback
+	mutex      sync.Mutex
+
+	published      map[int]time.Time
+	delivery       latencies
+	publishedMutex sync.Mutex
+
+	chats         atomic.Int64
+	chatErrors    atomic.Int64
+	switches      atomic.Int64
+	peakGoroutine atomic.Int64
+	peakHeap      atomic.Uint64
+}
+
+// latencies collects durations between reports.
+type latencies struct {
+	samples []time.Duration
+	mutex   sync.Mutex
+}
+
+// timedTransport measures how long the host takes to hand every payload to the transport.
+type timedTransport struct {
+	*transport.Loopback
+
+	send  latencies
+	calls atomic.Int64
+}
+
+func (t *timedTransport) SendPayloadVia(subClient int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	start := time.Now()
+	err := t.Loopback.SendPayloadVia(subClient, dests, payload, config)
+	t.send.add(time.Since(start))
+	t.calls.Add(1)
+	return err
+}
+
+// runLoadTest simulates viewers joining, pinging, switching quality and chatting: gonovon loadtest [flags]
+func runLoadTest(args []string) {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	numViewers := flags.Int("viewers", 1000, "number of synthetic viewers")
+	duration := flags.Duration("duration", time.Minute, "length of the test")
+	ramp := flags.Duration("ramp", 10*time.Second, "time over which viewers join")
+	segmentInterval := flags.Duration("segment-interval", 2*time.Second, "time between segments")
+	segmentSize := flags.Int("segment-size", 500000, "size of a source quality segment in bytes")
+	transcodes := flags.String("transcoders", "720p30,480p30", "comma separated quality levels besides source")
+	chatInterval := flags.Duration("chat-interval", time.Minute, "average time between chat messages of a viewer, 0 disables chat")
+	qualityInterval := flags.Duration("quality-interval", time.Minute, "average time between quality switches of a viewer, 0 disables switching")
+	reportInterval := flags.Duration("report", 5*time.Second, "time between reports")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonovon loadtest [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	l := &loadTest{
+		network:   transport.NewLoopbackNetwork(),
+		published: make(map[int]time.Time),
+	}
+
+	if err := l.startHost(*transcodes); err != nil {
+		log.Fatalln("could not start host:", err)
+	}
+	log.Println("Load test -", "viewers:", *numViewers, "quality levels:", len(currentSource().Transcoders)+1, "duration:", *duration)
+
+	done := make(chan struct{})
+	go l.sample(done)
+	go l.publishSegments(*segmentSize, *segmentInterval, done)
+	go l.joinViewers(*numViewers, *ramp, *chatInterval, *qualityInterval, done)
+
+	ticker := time.NewTicker(*reportInterval)
+	defer ticker.Stop()
+	end := time.After(*duration)
+	for {
+		select {
+		case <-ticker.C:
+			l.report()
+		case <-end:
+			close(done)
+			l.report()
+			l.close()
+			return
+		}
+	}
+}
+
+// startHost points the host globals at a loopback transport, so the real message handling and publishing code runs.
+func (l *loadTest) startHost(transcodes string) error {
+	loopback, err := l.network.NewTransport()
+	if err != nil {
+		return err
+	}
+	l.host = &timedTransport{Loopback: loopback}
+	client = l.host
+	sender = NewSendScheduler(client)
+	sender.Start()
+
+	config := &Config{Title: "loadtest"}
+	if transcodes != "" {
+		config.Transcoders = strings.Split(transcodes, ",")
+	}
+	hostConfig.Store(config)
+
+	source := &SourceInfo{Codec: "h264", Resolution: 1080, Framerate: 30}
+	source.Transcoders = getTranscoders(config, source)
+	streamSource.Store(source)
+
+	viewers = NewViewers(30 * time.Second)
+	viewers.StartCleanup(time.Second)
+
+	receiveMessages()
+	return nil
+}
+
+// publishSegments broadcasts random segments in every quality level, sized by resolution.
+func (l *loadTest) publishSegments(segmentSize int, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		id := int(segmentId.Load())
+		source := currentSource()
+		levels := make([][][]byte, 0, len(source.Transcoders)+1)
+		levels = append(levels, ChunkByByteSizeWithMetadata(randomSegment(segmentSize), CHUNK_SIZE, id))
+		for _, transcode := range source.Transcoders {
+			size := segmentSize * transcode.Resolution / source.Resolution
+			levels = append(levels, ChunkByByteSizeWithMetadata(randomSegment(size), CHUNK_SIZE, id))
+		}
+
+		l.publishedMutex.Lock()
+		l.published[id] = time.Now()
+		delete(l.published, id-100)
+		l.publishedMutex.Unlock()
+
+		lastRtmpSegment.Store(time.Now().UnixNano())
+		broadcastChunks(id, levels)
+		segmentId.Add(1)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func randomSegment(size int) []byte {
+	segment := make([]byte, size)
+	rand.Read(segment)
+	return segment
+}
+
+// joinViewers spreads the viewers joining over the ramp time.
+func (l *loadTest) joinViewers(numViewers int, ramp time.Duration, chatInterval time.Duration, qualityInterval time.Duration, done chan struct{}) {
+	delay := ramp / time.Duration(max(numViewers, 1))
+	for i := 0; i < numViewers; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		loopback, err := l.network.NewTransport()
+		if err != nil {
+			log.Println("error creating viewer transport", err.Error())
+			continue
+		}
+
+		v := viewer.New(loopback, l.host.Address(), &viewer.Config{Quality: mathrand.Intn(len(currentSource().Transcoders) + 1)})
+		if err := v.Start(); err != nil {
+			log.Println("error starting viewer", err.Error())
+			loopback.Close()
+			continue
+		}
+
+		l.mutex.Lock()
+		l.viewers = append(l.viewers, v)
+		l.transports = append(l.transports, loopback)
+		l.mutex.Unlock()
+
+		go l.receive(v)
+		go l.act(v, i, chatInterval, qualityInterval, done)
+
+		time.Sleep(delay)
+	}
+}
+
+// receive drains a viewer and records how long segments took from publishing to complete delivery.
+func (l *loadTest) receive(v *viewer.Viewer) {
+	go func() {
+		for range v.Chat {
+		}
+	}()
+	go func() {
+		for range v.Text {
+		}
+	}()
+
+	for segment := range v.Segments {
+		l.publishedMutex.Lock()
+		published, ok := l.published[segment.Id]
+		l.publishedMutex.Unlock()
+
+		// Join replays are sent long after publishing, they would skew the delivery latency
+		if ok && segment.FirstSeen.After(published) && segment.Completed.Sub(published) < 10*time.Second {
+			l.delivery.add(segment.Completed.Sub(published))
+		}
+	}
+}
+
+// act lets a viewer chat and switch quality at random, on average once per interval.
+func (l *loadTest) act(v *viewer.Viewer, n int, chatInterval time.Duration, qualityInterval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		if chatInterval > 0 && mathrand.Int63n(int64(chatInterval)) < int64(time.Second) {
+			if err := v.SendChat(fmt.Sprintf("load test message from viewer %v", n)); err != nil {
+				l.chatErrors.Add(1)
+			} else {
+				l.chats.Add(1)
+			}
+		}
+
+		if qualityInterval > 0 && mathrand.Int63n(int64(qualityInterval)) < int64(time.Second) {
+			if _, err := v.SetQuality(mathrand.Intn(len(currentSource().Transcoders) + 1)); err == nil {
+				l.switches.Add(1)
+			}
+		}
+	}
+}
+
+// sample tracks the peak goroutine count and heap size.
+func (l *loadTest) sample(done chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var mem runtime.MemStats
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		if goroutines := int64(runtime.NumGoroutine()); goroutines > l.peakGoroutine.Load() {
+			l.peakGoroutine.Store(goroutines)
+		}
+		runtime.ReadMemStats(&mem)
+		if mem.HeapAlloc > l.peakHeap.Load() {
+			l.peakHeap.Store(mem.HeapAlloc)
+		}
+	}
+}
+
+func (l *loadTest) report() {
+	l.mutex.Lock()
+	joined := len(l.viewers)
+	var dropped int64
+	for _, t := range l.transports {
+		dropped += t.Dropped()
+	}
+	stats := viewer.Stats{}
+	for _, v := range l.viewers {
+		s := v.Stats()
+		stats.Completed += s.Completed
+		stats.Discarded += s.Discarded
+		stats.Duplicates += s.Duplicates
+		stats.Bytes += s.Bytes
+	}
+	l.mutex.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	fmt.Printf("viewers: %v/%v joined, segment: %v\n", viewers.Count(), joined, segmentId.Load())
+	fmt.Printf("  send latency:     %v (%v sends)\n", l.host.send.summary(), l.host.calls.Load())
+	fmt.Printf("  delivery latency: %v\n", l.delivery.summary())
+	fmt.Printf("  segments: %v completed, %v discarded, %v duplicate chunks, %.1f MB received\n",
+		stats.Completed, stats.Discarded, stats.Duplicates, float64(stats.Bytes)/1e6)
+	sendStats := sender.Stats()
+	fmt.Printf("  send queue: %v queued, %v sent, %v errors, %v dropped full, %v dropped stale\n",
+		sendStats.QueueLength, sendStats.Sent, sendStats.Errors, sendStats.DroppedFull, sendStats.DroppedStale)
+	fmt.Printf("  dropped chunks: %v\n", dropped)
+	fmt.Printf("  chat: %v sent, %v failed, quality switches: %v\n", l.chats.Load(), l.chatErrors.Load(), l.switches.Load())
+	fmt.Printf("  goroutines: %v (peak %v), heap: %.1f MB (peak %.1f MB), sys: %.1f MB\n",
+		runtime.NumGoroutine(), l.peakGoroutine.Load(), float64(mem.HeapAlloc)/1e6, float64(l.peakHeap.Load())/1e6, float64(mem.Sys)/1e6)
+}
+
+func (l *loadTest) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, v := range l.viewers {
+		v.Close()
+		l.transports[i].Close()
+	}
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.samples = append(l.samples, d)
+}
+
+// summary returns the percentiles since the last summary and starts a new interval.
+func (l *latencies) summary() string {
+	l.mutex.Lock()
+	samples := l.samples
+	l.samples = nil
+	l.mutex.Unlock()
+
+	if len(samples) == 0 {
+		return "no samples"
+	}
+
+	slices.Sort(samples)
+	percentile := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+	return fmt.Sprintf("p50 %v, p90 %v, p99 %v, max %v", percentile(50), percentile(90), percentile(99), samples[len(samples)-1])
+}
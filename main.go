@@ -5,29 +5,42 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"gonovon/transport"
+
 	"github.com/bluenviron/mediamtx/core"
 	"github.com/nknorg/nkn-sdk-go"
 )
 
-var client *nkn.MultiClient
+var client transport.Transport
 
 const NUM_SUB_CLIENTS = 96
 const VIEWER_SUB_CLIENTS = 3
 const CHUNK_SIZE = 64000
 
-var segmentId = 0
+// segmentId is the id of the next broadcasted segment.
+var segmentId atomic.Int64
+
+// lastSegment holds the chunks new viewers get first, thumbnail the latest screengrab.
+var lastSegment atomic.Pointer[[][]byte]
+var thumbnail atomic.Pointer[[]byte]
 
-var lastSegment [][]byte
-var thumbnail []byte
-var config *Config
+// hostConfig is the config in use, a reload replaces it as a whole.
+var hostConfig atomic.Pointer[Config]
+
+// currentConfig returns the config in use.
+func currentConfig() *Config {
+	return hostConfig.Load()
+}
 
 var viewers *Viewers
 
@@ -36,46 +49,128 @@ var segmentSendConfig = &nkn.MessageConfig{
 	NoReply:     true,
 }
 
-var lastRtmpSegment = time.Time{}
+// lastRtmpSegment is the time of the last received segment in unix nanoseconds, isLive reads it.
+var lastRtmpSegment atomic.Int64
 
-var sourceResolution int
-var sourceFramerate int
-var sourceCodec string
+// SourceInfo is the video of the current stream and the quality levels it is transcoded to. It is replaced as a
+// whole, so messages handled during a stream change never see half of it.
+type SourceInfo struct {
+	Codec       string
+	Resolution  int
+	Framerate   int
+	Transcoders []Transcode
+}
 
-var transcoders []Transcode
+var streamSource atomic.Pointer[SourceInfo]
+
+// currentSource returns the source of the current stream, an empty one before the first stream.
+func currentSource() *SourceInfo {
+	if source := streamSource.Load(); source != nil {
+		return source
+	}
+	return &SourceInfo{}
+}
 
 func main() {
+	if len(os.Args) > 1 && runCommand(os.Args[1], os.Args[2:]) {
+		return
+	}
+
 	fmt.Println("Welcome to go-novon a golang client for RTMP streaming to novon")
 	fmt.Println("")
 
+	var err error
+	hostOptions, err = parseHostOptions(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	if err := hostOptions.enterDataDir(); err != nil {
+		log.Fatalln("could not use data dir:", err)
+	}
+
 	checkFfmpegInstalled()
 
-	var err error
-	config, err = NewConfig("./config.json")
+	config, err := NewConfig(hostOptions.ConfigFile)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
+	hostConfig.Store(config)
+
+	recorder = NewRecorder(config)
+	recorder.Start()
+	chatLog = NewJSONLog(config.ChatLog)
+	donationLedger = NewDonationLedger(config)
+	dvr = NewDVR(config)
 
 	viewers = NewViewers(30 * time.Second)
 	viewers.StartCleanup(time.Second)
 	defer viewers.Cleanup()
 
 	client = createClient()
+	sender = NewSendScheduler(client)
+	sender.Start()
+
+	//A relay re-broadcasts its origin instead of receiving RTMP
+	var s *core.Core
+	relay = NewRelay(config)
+	pullSource = NewPullSource(config)
+	if relay != nil {
+		if err := relay.Start(); err != nil {
+			log.Fatalln("could not relay origin:", err)
+		}
+	} else if pullSource != nil {
+		//A pull source is restreamed instead of receiving RTMP
+		pullSource.Start()
+	} else {
+		if err := applyIngest(mediaMTXConfigFile(), config); err != nil {
+			log.Fatalln("could not configure ingest:", err)
+		}
 
-	s, ok := core.New(os.Args[1:], publishTSPart)
-	if !ok {
-		os.Exit(1)
+		var ok bool
+		s, ok = core.New(hostOptions.MediaMTXArgs, publishTSPart)
+		if !ok {
+			os.Exit(1)
+		}
+		checkStreamKey(mediaMTXConfigFile())
+		guardPublishingPaths(MEDIAMTX_API)
 	}
 
+	reruns = NewReruns()
+	reruns.StartSupervisor()
+
 	maintainStream()
-	loadPanels()
+	loadPanels(hostOptions.PanelsFile)
+	watchConfig(hostOptions.ConfigFile, hostOptions.PanelsFile)
 	receiveMessages()
 
-	s.Wait()
+	waitForShutdown(s)
+}
+
+// runCommand runs a gonovon subcommand, all other arguments are passed on to MediaMTX.
+func runCommand(command string, args []string) bool {
+	switch command {
+	case "watch":
+		runWatch(args)
+	case "loadtest":
+		runLoadTest(args)
+	case "directory":
+		runDirectory(args)
+	case "config":
+		runConfig(args)
+	case "keystore":
+		runKeystore(args)
+	case "wallet":
+		runWallet(args)
+	case "streamkey":
+		runStreamKey(args)
+	default:
+		return false
+	}
+	return true
 }
 
-func createClient() *nkn.MultiClient {
-	seed, _ := hex.DecodeString(config.Seed)
+func createClient() transport.Transport {
+	seed, _ := hex.DecodeString(currentConfig().Seed)
 	account, err := nkn.NewAccount(seed)
 	if err != nil {
 		log.Panic(err)
@@ -93,116 +188,182 @@ func createClient() *nkn.MultiClient {
 	log.Println("connected to NKN")
 	log.Println("Your address", client.Address())
 
-	return client
+	return transport.NewNKN(client, NUM_SUB_CLIENTS)
 }
 
 type ChannelInfo struct {
-	Panels        string      `json:"panels"`
-	Viewers       int         `json:"viewers"`
-	Role          string      `json:"role"`
-	QualityLevels []Transcode `json:"qualityLevels"`
+	Panels        string        `json:"panels"`
+	Viewers       int           `json:"viewers"`
+	Role          string        `json:"role"`
+	QualityLevels []Transcode   `json:"qualityLevels"`
+	Mode          string        `json:"mode"`
+	Dvr           *DvrWindow    `json:"dvr,omitempty"`
+	Origin        string        `json:"origin,omitempty"`
+	Source        *SourceStatus `json:"source,omitempty"`
 }
 
 func receiveMessages() {
 	go func() {
 		for {
-			msg := <-client.OnMessage.C
+			msg := <-client.OnMessage().C
 			if msg == nil {
 				continue
 			}
+			handleMessage(msg)
+		}
+	}()
+}
 
-			//Always reply to panel, this can be displayed when we are not broadcasting.
-			if len(msg.Data) == 9 && string(msg.Data[:]) == "getpanels" {
-				go replyText(panels, msg)
-				continue
-			}
+func handleMessage(msg *nkn.Message) {
+	//Always reply to panel, this can be displayed when we are not broadcasting.
+	if len(msg.Data) == 9 && string(msg.Data[:]) == "getpanels" {
+		go replyText(currentPanels(), msg)
+		return
+	}
 
-			//Always reply to panel, this can be displayed when we are not broadcasting.
-			if len(msg.Data) == 11 && string(msg.Data[:]) == "channelinfo" {
+	//Always reply to panel, this can be displayed when we are not broadcasting.
+	if len(msg.Data) == 11 && string(msg.Data[:]) == "channelinfo" {
+		pullSource.Demand()
+
+		role := roleOf(msg.Src)
+
+		source := currentSource()
+		qualityLevels := make([]Transcode, 0)
+		qualityLevels = append(qualityLevels, Transcode{
+			Resolution: source.Resolution,
+			Framerate:  source.Framerate,
+		})
+
+		qualityLevels = append(qualityLevels, source.Transcoders...)
+
+		response := ChannelInfo{
+			Panels:        currentPanels(),
+			Viewers:       viewers.Count(),
+			Role:          role,
+			QualityLevels: qualityLevels,
+			Mode:          streamMode(),
+			Dvr:           dvr.Window(),
+			Origin:        relay.Origin(),
+		}
+		//The source URL is only shown to owners and moderators
+		if role != "" {
+			response.Source = pullSource.Status()
+		}
 
-				role := ""
-				if msg.Src == config.Owner {
-					role = "owner"
-				}
+		json, err := json.Marshal(response)
+		if err != nil {
+			log.Println("error on creating channel info response", err.Error())
+		}
 
-				qualityLevels := make([]Transcode, 0)
-				qualityLevels = append(qualityLevels, Transcode{
-					Resolution: sourceResolution,
-					Framerate:  sourceFramerate,
-				})
+		go replyText(string(json), msg)
+		return
+	}
 
-				qualityLevels = append(qualityLevels, transcoders...)
+	//Viewers waiting for an on-demand source start it
+	if len(msg.Data) == 4 && string(msg.Data[:]) == "ping" {
+		pullSource.Demand()
+	}
 
-				response := ChannelInfo{
-					Panels:        panels,
-					Viewers:       len(viewerAddresses),
-					Role:          role,
-					QualityLevels: qualityLevels,
-				}
+	//If we're not broadcasting don't reply to anything.
+	if !isBroadcasting() {
+		time.Sleep(time.Millisecond * 100)
+		return
+	}
 
-				json, err := json.Marshal(response)
-				if err != nil {
-					log.Println("error on creating channel info response", err.Error())
+	if len(msg.Data) == 4 && string(msg.Data[:]) == "ping" {
+		isNew := viewers.AddOrUpdateAddress(msg.Src)
+		if isNew {
+			log.Println("viewer joined: ", msg.Src)
+		}
+		//Send last segment to newly joined
+		if isNew {
+			if chunks := lastSegment.Load(); chunks != nil {
+				for _, chunk := range *chunks {
+					go sendToClient(msg.Src, chunk)
 				}
-
-				go replyText(string(json), msg)
-				continue
 			}
+		}
+	} else if len(msg.Data) == 9 && string(msg.Data[:]) == "thumbnail" {
+		go reply(currentThumbnail(), msg)
+	} else if len(msg.Data) == 10 && string(msg.Data[:]) == "disconnect" {
+		viewers.Remove(msg.Src)
+	} else if len(msg.Data) == 9 && string(msg.Data[:]) == "viewcount" {
+		go replyText(strconv.Itoa(viewers.Count()), msg)
+	} else if len(msg.Data) == 10 && string(msg.Data[:]) == "donationid" {
+		go replyText(generateDonationEntry(), msg)
+	} else if len(msg.Data) == 8 && strings.Contains(string(msg.Data[:]), "quality") {
+		qLevelStr, _ := strings.CutPrefix(string(msg.Data[:]), "quality")
+		qLevel, _ := strconv.Atoi(qLevelStr)
+		viewers.SetQuality(msg.Src, qLevel)
+		go replyText(strconv.FormatInt(segmentId.Load(), 10), msg)
+	} else if strings.HasPrefix(string(msg.Data[:]), "segment ") {
+		go replySegment(msg)
+	} else {
+		DecodeMessage(msg)
+	}
+}
 
-			//If we're not broadcasting don't reply to anything.
-			if !isBroadcasting() {
-				time.Sleep(time.Millisecond * 100)
-				continue
-			}
+// replySegment sends a segment from the dvr window to a viewer, requested as "segment <id> <quality>".
+func replySegment(msg *nkn.Message) {
+	fields := strings.Fields(string(msg.Data[:]))
+	if len(fields) != 3 {
+		replyText("error: expected segment <id> <quality>", msg)
+		return
+	}
 
-			if len(msg.Data) == 4 && string(msg.Data[:]) == "ping" {
-				isNew := viewers.AddOrUpdateAddress(msg.Src)
-				if isNew {
-					log.Println("viewer joined: ", msg.Src)
-				}
-				//Send last segment to newly joined
-				if isNew {
-					for _, chunk := range lastSegment {
-						go sendToClient(msg.Src, chunk)
-					}
-				}
-			} else if len(msg.Data) == 9 && string(msg.Data[:]) == "thumbnail" {
-				go reply(thumbnail, msg)
-			} else if len(msg.Data) == 10 && string(msg.Data[:]) == "disconnect" {
-				viewers.Remove(msg.Src)
-			} else if len(msg.Data) == 9 && string(msg.Data[:]) == "viewcount" {
-				go replyText(strconv.Itoa(len(viewerAddresses)), msg)
-			} else if len(msg.Data) == 10 && string(msg.Data[:]) == "donationid" {
-				go replyText(generateDonationEntry(), msg)
-			} else if len(msg.Data) == 8 && strings.Contains(string(msg.Data[:]), "quality") {
-				qLevelStr, _ := strings.CutPrefix(string(msg.Data[:]), "quality")
-				qLevel, _ := strconv.Atoi(qLevelStr)
-				viewers.viewerQuality[msg.Src] = qLevel
-				go replyText(strconv.Itoa(segmentId), msg)
-			} else {
-				DecodeMessage(msg)
-			}
-		}
-	}()
+	id, err := strconv.Atoi(fields[1])
+	if err != nil {
+		replyText("error: invalid segment id", msg)
+		return
+	}
+
+	qLevel, err := strconv.Atoi(fields[2])
+	if err != nil {
+		replyText("error: invalid quality", msg)
+		return
+	}
+
+	chunks, err := dvr.Get(id, qLevel)
+	if err != nil {
+		replyText("error: "+err.Error(), msg)
+		return
+	}
+
+	for _, chunk := range chunks {
+		go sendToClient(msg.Src, chunk)
+	}
+	replyText(strconv.Itoa(id), msg)
 }
 
 func maintainStream() {
 	isSubscribed := false
+	wasLive := false
 	lastSubscribe := time.Time{}
+	lastMeta := ""
 
 	go func() {
 		for {
+			// The live stream ended, finalize its recording
+			if wasLive && !isLive() {
+				recorder.EndSession()
+			}
+			wasLive = isLive()
+			chatLog.Flush()
+
 			if isBroadcasting() {
-				// We're receiving segments, subscribe if not already, or if we need a resub
-				if !isSubscribed || time.Since(lastSubscribe).Seconds() > 100*20 {
+				// We're receiving segments, subscribe if not already, if we need a resub, or if the metadata changed
+				meta := subscriptionMetadata()
+				metaChanged := meta != lastMeta && (time.Since(lastSubscribe) > SUBSCRIPTION_REFRESH_INTERVAL || forceResubscribe.Swap(false))
+				if !isSubscribed || time.Since(lastSubscribe).Seconds() > 100*20 || metaChanged {
 					lastSubscribe = time.Now()
-					go client.Subscribe("", "novon", 100, config.Title, nil)
+					lastMeta = meta
+					go client.Subscribe("novon", 100, meta)
 					isSubscribed = true
 				}
 			} else {
 				// No recent segments, unsubscribe if subscribed
 				if isSubscribed {
-					go client.Unsubscribe("", "novon", nil)
+					go client.Unsubscribe("novon")
 					isSubscribed = false
 				}
 			}
@@ -216,7 +377,7 @@ func ChunkByByteSizeWithMetadata(data []byte, chunkSize int, segmentId int) [][]
 		panic("chunkSize must be positive")
 	}
 
-	totalChunks := (len(data) / chunkSize) + 1
+	totalChunks := (len(data) + chunkSize - 1) / chunkSize
 	chunks := make([][]byte, 0, totalChunks)
 
 	chunkId := 0
@@ -241,59 +402,79 @@ func ChunkByByteSizeWithMetadata(data []byte, chunkSize int, segmentId int) [][]
 }
 
 func publishTSPart(segment []byte) {
+	if refuseOpenPublishing() {
+		return
+	}
 
-	if !isBroadcasting() {
+	//Segments arrive in real time, the recorder takes the duration of a segment from the arrival of the next one.
+	received := time.Now()
+
+	if !isLive() {
+		reruns.Stop()
+
+		//SRT and WebRTC publishers are probed like RTMP ones, a segment without usable video is dropped
+		source := &SourceInfo{}
 		info, err := probeVideoInfo(segment)
+		if err == nil {
+			source.Codec, source.Resolution, source.Framerate, err = parseSourceInfo(info)
+		}
 		if err != nil {
-			panic(err)
+			log.Println("Dropping segment:", err)
+			return
 		}
 
-		sourceCodec = info["codec"]
-		sourceResolution, _ = strconv.Atoi(strings.Split(info["resolution"], "x")[1])
-		sourceFramerate, _ = strconv.Atoi(strings.Split(info["framerate"], "/")[0])
-
-		log.Println("Receiving codec:", sourceCodec, "resolution:", sourceResolution, "framerate:", sourceFramerate)
+		log.Println("Receiving codec:", source.Codec, "resolution:", source.Resolution, "framerate:", source.Framerate)
 
-		transcoders = getTranscoders(config)
-		for _, v := range transcoders {
+		source.Transcoders = getTranscoders(currentConfig(), source)
+		for _, v := range source.Transcoders {
 			log.Println("Stream will be transcoded in:", v.Resolution, "p", v.Framerate)
 		}
+		streamSource.Store(source)
+
+		recorder.StartSession()
+		transcodersChanged.Store(false)
+	} else if transcodersChanged.Swap(false) {
+		source := *currentSource()
+		source.Transcoders = getTranscoders(currentConfig(), &source)
+		streamSource.Store(&source)
+		log.Println("Transcoder ladder reloaded, quality levels:", len(source.Transcoders)+1)
 	}
 
-	lastRtmpSegment = time.Now()
+	lastRtmpSegment.Store(time.Now().UnixNano())
 	//os.WriteFile("test.ts", segment, os.FileMode(0644))
 
+	//The ladder can be reloaded while this segment is transcoded
+	transcoders := currentSource().Transcoders
 	go func() {
-		sourceChunks := ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, segmentId)
+		id := int(segmentId.Load())
+		recorder.WriteSegment(qualityLevelName(nil), id, segment, received)
+
+		sourceChunks := ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, id)
 		transcodedChunksArray := make([][][]byte, 0)
 		transcodedChunksArray = append(transcodedChunksArray, sourceChunks)
 
 		//No transcoding, publish to all viewers in source quality.
 		if len(transcoders) == 0 {
-			log.Println("Broadcasting -", "viewers:", len(viewerAddresses), "source size:", len(segment), "source chunks:", len(sourceChunks))
-			for i := 0; i < len(sourceChunks); i++ {
-				go publish(sourceChunks[i])
-			}
-			segmentId++
+			log.Println("Broadcasting -", "viewers:", viewers.Count(), "source size:", len(segment), "source chunks:", len(sourceChunks))
+			broadcastChunks(id, transcodedChunksArray)
+			segmentId.Add(1)
 		} else {
 
 			startTranscoderTime := time.Now()
-			log.Println("Broadcasting -", "viewers:", len(viewerAddresses), "source size:", len(segment), "source chunks:", len(sourceChunks))
+			log.Println("Broadcasting -", "viewers:", viewers.Count(), "source size:", len(segment), "source chunks:", len(sourceChunks))
 			for _, t := range transcoders {
 
 				beginTime := time.Now()
 				segment = resizeSegment(t, segment)
 				timeSpent := time.Since(beginTime).Milliseconds()
+				recorder.WriteSegment(qualityLevelName(&t), id, segment, received)
 
-				tChunks := ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, segmentId)
+				tChunks := ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, id)
 				transcodedChunksArray = append(transcodedChunksArray, tChunks)
 				log.Printf("Transcoded -%v@%v size: %v, chunks: %v, timeSpent: %v\n", t.Resolution, t.Framerate, len(segment), len(tChunks), timeSpent)
 			}
-			segmentId++
-
-			if len(viewerAddresses) > 0 {
-				publishQualityLevels(transcodedChunksArray...)
-			}
+			segmentId.Add(1)
+			broadcastChunks(id, transcodedChunksArray)
 
 			totalTranscodingMs := time.Since(startTranscoderTime).Milliseconds()
 			if totalTranscodingMs > 1000 && totalTranscodingMs < 2000 {
@@ -303,53 +484,92 @@ func publishTSPart(segment []byte) {
 			}
 		}
 
-		if (segmentId-1)%10 == 0 {
+		if id%10 == 0 {
 			go screengrabSegment(segment)
 		}
 
-		//For fastest join times we take the lowest quality level
-		lastSegment = transcodedChunksArray[len(transcodedChunksArray)-1]
+		if id%10 == 0 {
+			stats := sender.Stats()
+			log.Printf("Send queue - sent: %v, errors: %v, dropped full: %v, dropped stale: %v, queued: %v, avg send: %v\n",
+				stats.Sent, stats.Errors, stats.DroppedFull, stats.DroppedStale, stats.QueueLength, stats.AvgSendTime)
+
+			if nknClient, ok := client.(*transport.NKN); ok {
+				logSubClientHealth(nknClient.Health())
+			}
+		}
 	}()
 }
 
+// broadcastChunks publishes the chunks of every quality level of one segment, source quality first.
+func broadcastChunks(id int, transcodedChunksArray [][][]byte) {
+	//No transcoding, publish to all viewers in source quality.
+	if len(transcodedChunksArray) == 1 {
+		for i := 0; i < len(transcodedChunksArray[0]); i++ {
+			publish(transcodedChunksArray[0][i], true)
+		}
+	} else if viewers.Count() > 0 {
+		publishQualityLevels(transcodedChunksArray...)
+	}
+
+	//For fastest join times we take the lowest quality level
+	lastSegment.Store(&transcodedChunksArray[len(transcodedChunksArray)-1])
+
+	dvr.Add(id, transcodedChunksArray)
+}
+
+// logSubClientHealth logs how many sub-clients are connected and which ones are failing or slow.
+func logSubClientHealth(health []transport.SubClientHealth) {
+	connected := 0
+	sending := 0
+	var total time.Duration
+	for _, h := range health {
+		if h.Connected {
+			connected++
+		}
+		if h.Latency > 0 {
+			sending++
+			total += h.Latency
+		}
+	}
+	average := total / time.Duration(max(sending, 1))
+
+	log.Println("Sub-clients -", "connected:", connected, "of", len(health), "avg latency:", average)
+	for i, h := range health {
+		if h.Connected && h.Latency > transport.SLOW_SUB_CLIENT_FACTOR*average {
+			log.Println("Sub-client", i, "is slow, latency:", h.Latency, "sent:", h.Sent, "errors:", h.Errors)
+		}
+	}
+}
+
 func screengrabSegment(segment []byte) {
 	// Output image file
 	width := "256"
 	height := "144"
 
-	// Command arguments for ffmpeg
-	cmd := exec.Command("ffmpeg",
+	// Command arguments for ffmpeg, read MPEG-TS data from stdin (pipe)
+	grab, stderr, err := commands.Run("ffmpeg", []string{
 		"-i", "-", // read from stdin (pipe)
 		"-vframes", "1",
 		"-vf", fmt.Sprintf("scale=%s:%s", width, height), // resize filter
 		"-f",
 		"image2pipe",
-		"-")
-
-	var stdinPipe, stderrPipe bytes.Buffer
-	cmd.Stdin = &stdinPipe
-	cmd.Stderr = &stderrPipe
-
-	// Write MPEG-TS data to stdin pipe
-	stdinPipe.Write(segment)
-
-	var err error
-	thumbnail, err = cmd.Output()
+		"-"}, segment)
 
 	if err != nil {
 		log.Println("Error capturing screenshot:", err)
-		log.Println("FFmpeg stderr:", stderrPipe.String())
+		log.Println("FFmpeg stderr:", string(stderr))
 		return
 	}
 
+	thumbnail.Store(&grab)
 	log.Println("Screenshot captured successfully.")
 }
 
 func resizeSegment(transcode Transcode, segment []byte) []byte {
 	//ultrafast superfast veryfast faster fast medium (default) slow slower veryslow
 
-	// Command arguments for ffmpeg
-	cmd := exec.Command("ffmpeg",
+	// Command arguments for ffmpeg, read MPEG-TS data from stdin (pipe)
+	resizedSegment, stderr, err := commands.Run("ffmpeg", []string{
 		"-hwaccel", "auto",
 		"-i", "-", // read from stdin (pipe)
 		"-c:v", "libx264", // specify video encoder (optional)
@@ -359,20 +579,11 @@ func resizeSegment(transcode Transcode, segment []byte) []byte {
 		"-filter:v", fmt.Sprintf("scale=-2:%d,fps=%d", transcode.Resolution, transcode.Framerate),
 		"-copyts",
 		"-f", "mpegts",
-		"-")
-
-	var stdinPipe, stderrPipe bytes.Buffer
-	cmd.Stdin = &stdinPipe
-	cmd.Stderr = &stderrPipe
-
-	// Write MPEG-TS data to stdin pipe
-	stdinPipe.Write(segment)
-
-	resizedSegment, err := cmd.Output()
+		"-"}, segment)
 
 	if err != nil {
 		log.Println("Error capturing screenshot:", err)
-		log.Println("FFmpeg stderr:", stderrPipe.String())
+		log.Println("FFmpeg stderr:", string(stderr))
 		return nil
 	}
 
@@ -380,17 +591,8 @@ func resizeSegment(transcode Transcode, segment []byte) []byte {
 }
 
 func probeVideoInfo(segment []byte) (map[string]string, error) {
-	// Create ffprobe command with pipe input
-	cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", "-i", "-")
-
-	var stdinPipe bytes.Buffer
-	cmd.Stdin = &stdinPipe
-
-	// Write MPEG-TS data to stdin pipe
-	stdinPipe.Write(segment)
-
-	// Capture ffprobe output
-	out, err := cmd.CombinedOutput()
+	// Run ffprobe with pipe input
+	out, _, err := commands.Run("ffprobe", []string{"-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", "-i", "-"}, segment)
 	if err != nil {
 		return nil, fmt.Errorf("error probing video info: %w", err)
 	}
@@ -424,11 +626,35 @@ func probeVideoInfo(segment []byte) (map[string]string, error) {
 	return result, nil
 }
 
+// parseSourceInfo reads the codec, vertical resolution and framerate of the probed source video.
+func parseSourceInfo(info map[string]string) (string, int, int, error) {
+	if info["codec"] == "" {
+		return "", 0, 0, errors.New("no video stream, publish H264 or H265 video")
+	}
+
+	_, height, _ := strings.Cut(info["resolution"], "x")
+	resolution, err := strconv.Atoi(height)
+	if err != nil || resolution <= 0 {
+		return "", 0, 0, fmt.Errorf("unknown resolution %q", info["resolution"])
+	}
+
+	//Encoders report fractional framerates like 30000/1001
+	numerator, denominator, found := strings.Cut(info["framerate"], "/")
+	num, err := strconv.ParseFloat(numerator, 64)
+	den := 1.0
+	if err == nil && found {
+		den, err = strconv.ParseFloat(denominator, 64)
+	}
+	if err != nil || num <= 0 || den <= 0 {
+		return "", 0, 0, fmt.Errorf("unknown framerate %q", info["framerate"])
+	}
+
+	return info["codec"], resolution, int(math.Round(num / den)), nil
+}
+
 func checkFfmpegInstalled() {
 	// Command to check for ffmpeg (replace with actual command if needed)
-	cmd := exec.Command("ffmpeg", "-version")
-
-	err := cmd.Run()
+	_, _, err := commands.Run("ffmpeg", []string{"-version"}, nil)
 	if err != nil {
 		// Handle ffmpeg not found error
 		log.Println("Error: ffmpeg is not installed. Please install ffmpeg and try again.")
@@ -440,5 +666,17 @@ func checkFfmpegInstalled() {
 }
 
 func isBroadcasting() bool {
-	return time.Since(lastRtmpSegment).Seconds() < 5
+	return isLive() || reruns.IsPlaying()
+}
+
+func isLive() bool {
+	return time.Since(time.Unix(0, lastRtmpSegment.Load())).Seconds() < 5
+}
+
+// currentThumbnail returns the latest screengrab, nil before the first one.
+func currentThumbnail() []byte {
+	if grab := thumbnail.Load(); grab != nil {
+		return *grab
+	}
+	return nil
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gonovon/viewer"
+)
+
+func TestChunkByByteSizeWithMetadata(t *testing.T) {
+	segment := loadFixture(t)
+
+	chunks := ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, 7)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %v", len(chunks))
+	}
+
+	rebuilt := make([]byte, 0, len(segment))
+	for i, chunk := range chunks {
+		if id := binary.LittleEndian.Uint32(chunk[:4]); id != 7 {
+			t.Errorf("chunk %v: expected segment id 7, got %v", i, id)
+		}
+		if chunkId := binary.LittleEndian.Uint32(chunk[4:8]); chunkId != uint32(i) {
+			t.Errorf("chunk %v: expected chunk id %v, got %v", i, i, chunkId)
+		}
+		if total := binary.LittleEndian.Uint32(chunk[8:12]); total != 3 {
+			t.Errorf("chunk %v: expected 3 total chunks, got %v", i, total)
+		}
+		rebuilt = append(rebuilt, chunk[12:]...)
+	}
+
+	if !bytes.Equal(rebuilt, segment) {
+		t.Error("rebuilt segment does not match the fixture")
+	}
+}
+
+func TestChunkByByteSizeWithMetadataExactMultiple(t *testing.T) {
+	chunks := ChunkByByteSizeWithMetadata(make([]byte, 2*CHUNK_SIZE), CHUNK_SIZE, 0)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %v", len(chunks))
+	}
+	if total := binary.LittleEndian.Uint32(chunks[1][8:12]); total != 2 {
+		t.Errorf("expected 2 total chunks, got %v", total)
+	}
+}
+
+func TestPublishTSPartSourceOnly(t *testing.T) {
+	resetHost()
+	segment := loadFixture(t)
+
+	viewers.AddOrUpdateAddress("viewer")
+	publishTSPart(segment)
+
+	expectedChunks := ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, 0)
+	waitFor(t, func() bool {
+		return len(hostTransport.binaryPayloads()) == len(expectedChunks)*VIEWER_SUB_CLIENTS
+	})
+
+	sentPerChunk := make(map[string][]string)
+	for _, sent := range hostTransport.binaryPayloads() {
+		sentPerChunk[string(sent.payload.Data)] = append(sentPerChunk[string(sent.payload.Data)], sent.dests...)
+	}
+
+	for i, chunk := range expectedChunks {
+		dests := sentPerChunk[string(chunk)]
+		if len(dests) != VIEWER_SUB_CLIENTS {
+			t.Fatalf("chunk %v: expected %v sends, got %v", i, VIEWER_SUB_CLIENTS, len(dests))
+		}
+		for j := 0; j < VIEWER_SUB_CLIENTS; j++ {
+			expected := "__" + strconv.Itoa(j) + "__.viewer"
+			found := false
+			for _, dest := range dests {
+				found = found || dest == expected
+			}
+			if !found {
+				t.Errorf("chunk %v: not sent to %v", i, expected)
+			}
+		}
+	}
+
+	if source := currentSource(); source.Resolution != 1080 || source.Framerate != 30 || source.Codec != "h264" {
+		t.Errorf("unexpected probe result: %+v", source)
+	}
+	waitFor(t, func() bool { return segmentId.Load() == 1 })
+}
+
+func TestPublishTSPartQualityRouting(t *testing.T) {
+	resetHost()
+	segment := loadFixture(t)
+	hostConfig.Store(&Config{Title: "test", Transcoders: []string{"720p30", "480p"}})
+
+	// The first segment starts the broadcast, viewers can only join once broadcasting.
+	publishTSPart(segment)
+	waitFor(t, func() bool { return segmentId.Load() == 1 })
+
+	if transcoders := currentSource().Transcoders; len(transcoders) != 2 || transcoders[0].Resolution != 720 || transcoders[1].Resolution != 480 {
+		t.Fatalf("unexpected transcoders: %v", transcoders)
+	}
+
+	source := newTestViewer(t, 0)
+	lowest := newTestViewer(t, 2)
+
+	// Joining replays the last segment in the lowest quality.
+	for _, v := range []*viewer.Viewer{source, lowest} {
+		replay := nextSegment(t, v)
+		if replay.Id != 0 {
+			t.Errorf("expected replay of segment 0, got %v", replay.Id)
+		}
+		if !bytes.Equal(replay.Data, fakeTranscode(fakeTranscode(segment, 720), 480)) {
+			t.Error("join replay is not the lowest quality level")
+		}
+	}
+
+	publishTSPart(segment)
+
+	sourceSegment := nextSegment(t, source)
+	if sourceSegment.Id != 1 || !bytes.Equal(sourceSegment.Data, segment) {
+		t.Errorf("source viewer did not receive segment 1 in source quality")
+	}
+
+	lowestSegment := nextSegment(t, lowest)
+	if lowestSegment.Id != 1 || !bytes.Equal(lowestSegment.Data, fakeTranscode(fakeTranscode(segment, 720), 480)) {
+		t.Errorf("lowest quality viewer did not receive segment 1 in 480p")
+	}
+}
+
+func TestChannelInfoAndViewcount(t *testing.T) {
+	resetHost()
+	hostConfig.Store(&Config{Title: "test", Transcoders: []string{"720p30"}})
+
+	publishTSPart(loadFixture(t))
+	waitFor(t, func() bool { return segmentId.Load() == 1 })
+
+	v := newTestViewer(t, 0)
+	waitFor(t, func() bool { return viewers.Count() == 1 })
+
+	info, err := v.ChannelInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Viewers != 1 || info.Mode != MODE_LIVE || len(info.QualityLevels) != 2 {
+		t.Errorf("unexpected channel info: %+v", info)
+	}
+	if info.QualityLevels[0].Resolution != 1080 || info.QualityLevels[1].Resolution != 720 {
+		t.Errorf("unexpected quality levels: %+v", info.QualityLevels)
+	}
+
+	count, err := v.Request("viewcount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(count) != "1" {
+		t.Errorf("expected viewcount 1, got %v", string(count))
+	}
+
+	v.Close()
+	waitFor(t, func() bool { return viewers.Count() == 0 })
+}
+
+func TestNotBroadcastingIgnoresViewers(t *testing.T) {
+	resetHost()
+
+	v := viewer.New(mustTransport(t), client.Address(), &viewer.Config{RequestTimeout: 300 * time.Millisecond})
+	if _, err := v.Request("viewcount"); err != viewer.ErrTimeout {
+		t.Errorf("expected viewcount to time out while not broadcasting, got %v", err)
+	}
+
+	info, err := v.ChannelInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode != MODE_OFFLINE {
+		t.Errorf("expected offline mode, got %v", info.Mode)
+	}
+}
+
+func TestScreengrabEveryTenSegments(t *testing.T) {
+	resetHost()
+	thumbnail.Store(nil)
+
+	publishTSPart(loadFixture(t))
+	waitFor(t, func() bool { return string(currentThumbnail()) == "thumbnail" })
+
+	fakeMedia.mutex.Lock()
+	defer fakeMedia.mutex.Unlock()
+	grabs := 0
+	for _, call := range fakeMedia.calls {
+		if strings.Contains(call, "image2pipe") {
+			grabs++
+		}
+	}
+	if grabs == 0 {
+		t.Error("expected a screengrab of the first segment")
+	}
+}
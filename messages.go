@@ -3,12 +3,25 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/nknorg/nkn-sdk-go"
 )
 
 var chatId uint64 = 0
 
+const CHAT_HISTORY_SIZE = 200
+
+// chatHistory keeps the most recent chat messages for clip chat excerpts.
+var chatHistory []chatHistoryEntry
+var chatHistoryMutex sync.Mutex
+
+type chatHistoryEntry struct {
+	time    time.Time
+	message ChatMessage
+}
+
 // Message struct represents a generic message with a type and content
 type Message struct {
 	Type    string          `json:"type"`
@@ -16,11 +29,18 @@ type Message struct {
 }
 
 type ChatMessage struct {
-	Id   uint64 `json:"id,string"`
-	Text string `json:"text"`
-	Hash string `json:"hash"`
-	Src  string `json:"src"`
-	Role string `json:"role"`
+	Id       uint64    `json:"id,string"`
+	Text     string    `json:"text"`
+	Hash     string    `json:"hash"`
+	Src      string    `json:"src"`
+	Role     string    `json:"role"`
+	Donation *Donation `json:"donation,omitempty"`
+}
+
+// chatLogEntry is a line of the chat log.
+type chatLogEntry struct {
+	Time string `json:"time"`
+	*ChatMessage
 }
 
 type DeleteChatMessage struct {
@@ -48,7 +68,7 @@ func DecodeMessage(receivedMessage *nkn.Message) {
 		HandleChatMessage(chatMsg, receivedMessage)
 	case "delete-chat-message":
 		{
-			if receivedMessage.Src == config.Owner {
+			if receivedMessage.Src == currentConfig().Owner {
 				var deleteMsg DeleteChatMessage
 				if err := json.Unmarshal(msg.Content, &deleteMsg); err != nil {
 					fmt.Println("Error unmarshalling message content:", err)
@@ -58,11 +78,59 @@ func DecodeMessage(receivedMessage *nkn.Message) {
 				publishText(string(receivedMessage.Data))
 			}
 		}
+	case "create-clip":
+		HandleCreateClip(msg.Content, receivedMessage)
+	case "list-clips":
+		HandleListClips(receivedMessage)
+	case "get-clip":
+		HandleGetClip(msg.Content, receivedMessage)
 	default:
 		fmt.Println("Unknown message type:", msg.Type, "content:", string(msg.Content))
 	}
 }
 
+// roleOf returns the channel role of an address: "owner", "moderator" or empty.
+func roleOf(address string) string {
+	config := currentConfig()
+	if address == config.Owner {
+		return "owner"
+	}
+	for _, moderator := range config.Moderators {
+		if address == moderator {
+			return "moderator"
+		}
+	}
+	return ""
+}
+
+func isOwnerOrModerator(address string) bool {
+	return roleOf(address) != ""
+}
+
+func addChatHistory(msg *ChatMessage) {
+	chatHistoryMutex.Lock()
+	defer chatHistoryMutex.Unlock()
+
+	chatHistory = append(chatHistory, chatHistoryEntry{time: time.Now(), message: *msg})
+	if len(chatHistory) > CHAT_HISTORY_SIZE {
+		chatHistory = chatHistory[len(chatHistory)-CHAT_HISTORY_SIZE:]
+	}
+}
+
+// chatSince returns the chat messages received after since.
+func chatSince(since time.Time) []ChatMessage {
+	chatHistoryMutex.Lock()
+	defer chatHistoryMutex.Unlock()
+
+	messages := make([]ChatMessage, 0)
+	for _, entry := range chatHistory {
+		if entry.time.After(since) {
+			messages = append(messages, entry.message)
+		}
+	}
+	return messages
+}
+
 func HandleChatMessage(msg *ChatMessage, nknMessage *nkn.Message) {
 	go func() {
 		fmt.Println("Message:", msg.Text)
@@ -70,22 +138,29 @@ func HandleChatMessage(msg *ChatMessage, nknMessage *nkn.Message) {
 		err := ValidateDonation(msg, true)
 		if err != nil {
 			fmt.Println("donation validation error", err.Error())
-			nknMessage.Reply([]byte("error: donation validation error - " + err.Error()))
+			client.Reply(nknMessage, []byte("error: donation validation error - "+err.Error()))
 			return
 		} else {
-			nknMessage.Reply([]byte("success"))
+			client.Reply(nknMessage, []byte("success"))
 		}
 
 		msg.Id = chatId
-		if msg.Src == config.Owner {
-			msg.Role = "owner"
-		}
+		msg.Role = roleOf(msg.Src)
 		chatId++
 
+		addChatHistory(msg)
+		chatLog.Append(chatLogEntry{Time: time.Now().UTC().Format(time.RFC3339), ChatMessage: msg})
+
+		//A relay publishes the chat of its origin, send ours there instead
+		if relay != nil {
+			relay.ForwardChat(msg)
+			return
+		}
+
 		binary, err := json.Marshal(msg)
 		if err != nil {
 			panic(err)
 		}
-		publish(binary)
+		publish(binary, false)
 	}()
 }
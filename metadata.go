@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// PROTOCOL_VERSION is announced in the subscription metadata so directory clients can tell host versions apart.
+const PROTOCOL_VERSION = 1
+
+// NKN rejects subscriptions with metadata longer than this.
+const MAX_SUBSCRIPTION_META_LEN = 1024
+
+// Changed metadata is announced at most once per interval, every subscription is a transaction.
+const SUBSCRIPTION_REFRESH_INTERVAL = 60 * time.Second
+
+// ChannelMetadata is the subscription metadata of the host on the novon topic.
+type ChannelMetadata struct {
+	Version   int      `json:"version"`
+	Title     string   `json:"title"`
+	Category  string   `json:"category,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Language  string   `json:"language,omitempty"`
+	Mature    bool     `json:"mature,omitempty"`
+	Mode      string   `json:"mode"`
+	Viewers   int      `json:"viewers"`
+	Thumbnail string   `json:"thumbnail,omitempty"` // sha256 of the current thumbnail
+	Origin    string   `json:"origin,omitempty"`
+}
+
+// subscriptionMetadata is the metadata of our "novon" topic subscription, a relay points to its origin.
+func subscriptionMetadata() string {
+	config := currentConfig()
+	meta := ChannelMetadata{
+		Version:  PROTOCOL_VERSION,
+		Title:    config.Title,
+		Category: config.Category,
+		Tags:     config.Tags,
+		Language: config.Language,
+		Mature:   config.Mature,
+		Mode:     streamMode(),
+		Viewers:  viewers.Count(),
+		Origin:   relay.Origin(),
+	}
+	if grab := currentThumbnail(); len(grab) > 0 {
+		hash := sha256.Sum256(grab)
+		meta.Thumbnail = hex.EncodeToString(hash[:])
+	}
+
+	return fitMetadata(meta)
+}
+
+// fitMetadata encodes the metadata within MAX_SUBSCRIPTION_META_LEN, dropping tags first and then shortening the
+// free text fields.
+func fitMetadata(meta ChannelMetadata) string {
+	for {
+		data, _ := json.Marshal(meta)
+		if len(data) <= MAX_SUBSCRIPTION_META_LEN {
+			return string(data)
+		}
+
+		switch {
+		case len(meta.Tags) > 0:
+			meta.Tags = meta.Tags[:len(meta.Tags)-1]
+		case len(meta.Category) > 0:
+			meta.Category = ""
+		case len(meta.Title) == 0:
+			return string(data)
+		default:
+			title := []rune(meta.Title)
+			meta.Title = string(title[:len(title)-min(len(title), max(len(data)-MAX_SUBSCRIPTION_META_LEN, 1))])
+		}
+	}
+}
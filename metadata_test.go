@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFitMetadata(t *testing.T) {
+	meta := ChannelMetadata{
+		Version:  PROTOCOL_VERSION,
+		Title:    strings.Repeat("ü", 600),
+		Category: "music",
+		Tags:     []string{strings.Repeat("a", 100), strings.Repeat("b", 100), strings.Repeat("c", 100)},
+	}
+
+	fitted := fitMetadata(meta)
+	if len(fitted) > MAX_SUBSCRIPTION_META_LEN {
+		t.Fatalf("metadata is %v bytes", len(fitted))
+	}
+
+	var decoded ChannelMetadata
+	if err := json.Unmarshal([]byte(fitted), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Tags) != 0 || decoded.Category != "" {
+		t.Errorf("expected tags and category to be dropped before the title is shortened: %+v", decoded)
+	}
+	if decoded.Title == "" || !strings.HasPrefix(meta.Title, decoded.Title) {
+		t.Errorf("expected a shortened title, got %q", decoded.Title)
+	}
+}
+
+func TestFitMetadataKeepsShortMetadata(t *testing.T) {
+	meta := ChannelMetadata{Version: PROTOCOL_VERSION, Title: "test", Tags: []string{"go", "music"}}
+
+	var decoded ChannelMetadata
+	if err := json.Unmarshal([]byte(fitMetadata(meta)), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != "test" || len(decoded.Tags) != 2 {
+		t.Errorf("unexpected metadata %+v", decoded)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const ENV_PREFIX = "GONOVON_"
+
+var hostOptions *HostOptions
+
+// HostOptions are the gonovon flags of the host, the remaining arguments belong to MediaMTX.
+type HostOptions struct {
+	ConfigFile   string
+	PanelsFile   string
+	DataDir      string
+	SeedFile     string
+	MediaMTXArgs []string
+}
+
+// parseHostOptions parses the gonovon flags in front of the MediaMTX arguments. Flag parsing stops at the first
+// argument that is not a flag or at "--", so "gonovon --config c.json mediamtx.yml" and
+// "gonovon --config c.json -- --version" both pass the rest on to MediaMTX.
+func parseHostOptions(args []string) (*HostOptions, error) {
+	options := &HostOptions{}
+
+	flags := flag.NewFlagSet("gonovon", flag.ContinueOnError)
+	flags.StringVar(&options.ConfigFile, "config", envOr("CONFIG", "./config.json"), "config file, or $GONOVON_CONFIG")
+	flags.StringVar(&options.PanelsFile, "panels", envOr("PANELS", PANELS_FILE), "panels file, or $GONOVON_PANELS")
+	flags.StringVar(&options.DataDir, "data-dir", envOr("DATA_DIR", ""), "directory relative paths are resolved in, or $GONOVON_DATA_DIR")
+	flags.StringVar(&options.SeedFile, "seed-file", envOr("SEED_FILE", ""), "file holding the wallet seed, or $GONOVON_SEED_FILE")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonovon [flags] [--] [MediaMTX arguments]")
+		fmt.Fprintln(os.Stderr, "       gonovon watch|directory|loadtest|config|keystore|wallet|streamkey ...")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "Every config.json field can be overridden with GONOVON_<FIELD>, like GONOVON_RECORD_MAX_AGE,")
+		fmt.Fprintln(os.Stderr, "or read from a file with GONOVON_<FIELD>_FILE.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	options.MediaMTXArgs = flags.Args()
+
+	// Paths that are set are relative to where gonovon was started, defaults are relative to the data dir
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, path := range map[string]*string{"config": &options.ConfigFile, "panels": &options.PanelsFile, "seed-file": &options.SeedFile} {
+		_, inEnv := os.LookupEnv(envName(strings.ReplaceAll(name, "-", "_")))
+		if *path == "" || !(set[name] || inEnv) {
+			continue
+		}
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return nil, err
+		}
+		*path = abs
+	}
+
+	return options, nil
+}
+
+// enterDataDir creates the data dir and makes it the working directory, so recordings, clips, the DVR buffer,
+// logs and the generated mediamtx.yml end up there.
+func (o *HostOptions) enterDataDir() error {
+	if o.DataDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(o.DataDir, 0755); err != nil {
+		return err
+	}
+	return os.Chdir(o.DataDir)
+}
+
+func envOr(name string, fallback string) string {
+	if value, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+		return value
+	}
+	return fallback
+}
+
+// envName converts a json field name like recordMaxAge to GONOVON_RECORD_MAX_AGE, a run of capitals is one word so
+// recordMaxSizeMB becomes GONOVON_RECORD_MAX_SIZE_MB.
+func envName(jsonName string) string {
+	runes := []rune(jsonName)
+	var sb strings.Builder
+	sb.WriteString(ENV_PREFIX)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previousLower := !unicode.IsUpper(runes[i-1])
+			endsRun := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || endsRun {
+				sb.WriteRune('_')
+			}
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+// applyEnvOverrides sets every config field that has a GONOVON_<FIELD> or GONOVON_<FIELD>_FILE variable.
+// Lists are comma separated or a JSON array.
+func applyEnvOverrides(cfg *Config) error {
+	value := reflect.ValueOf(cfg).Elem()
+	configType := value.Type()
+
+	for i := 0; i < configType.NumField(); i++ {
+		jsonName, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+		name := envName(jsonName)
+
+		override, ok := os.LookupEnv(name)
+		file, fileOk := os.LookupEnv(name + "_FILE")
+		//A relative GONOVON_SEED_FILE was resolved by parseHostOptions before entering the data dir
+		if jsonName == "seed" && hostOptions != nil && hostOptions.SeedFile != "" {
+			fileOk = false
+		}
+		if fileOk && !ok {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%v_FILE: %w", name, err)
+			}
+			override, ok = strings.TrimSpace(string(data)), true
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setField(value.Field(i), override); err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Slice:
+		list := make([]string, 0)
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			if err := json.Unmarshal([]byte(value), &list); err != nil {
+				return err
+			}
+		} else if value != "" {
+			for _, item := range strings.Split(value, ",") {
+				list = append(list, strings.TrimSpace(item))
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Kind())
+	}
+	return nil
+}
+
+// applyConfigOverrides applies the environment variables and the --seed-file flag to a config read from file.
+func applyConfigOverrides(cfg *Config) error {
+	if err := applyEnvOverrides(cfg); err != nil {
+		return err
+	}
+	if hostOptions == nil || hostOptions.SeedFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(hostOptions.SeedFile)
+	if err != nil {
+		return fmt.Errorf("seed file: %w", err)
+	}
+	cfg.Seed = strings.TrimSpace(string(data))
+	return nil
+}
+
+// seedOverridden reports whether the seed comes from the environment or a seed file instead of config.json.
+func seedOverridden() bool {
+	for _, name := range []string{"GONOVON_SEED", "GONOVON_SEED_FILE"} {
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+	}
+	return hostOptions != nil && hostOptions.SeedFile != ""
+}
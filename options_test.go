@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseHostOptionsSeparatesMediaMTXArgs(t *testing.T) {
+	options, err := parseHostOptions([]string{"--config", "/etc/gonovon.json", "--data-dir", "/data", "mediamtx.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.ConfigFile != "/etc/gonovon.json" || options.DataDir != "/data" || options.PanelsFile != PANELS_FILE {
+		t.Errorf("unexpected options: %+v", options)
+	}
+	if !slices.Equal(options.MediaMTXArgs, []string{"mediamtx.yml"}) {
+		t.Errorf("unexpected MediaMTX args: %v", options.MediaMTXArgs)
+	}
+
+	options, err = parseHostOptions([]string{"--panels", "p.json", "--", "--version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(options.MediaMTXArgs, []string{"--version"}) {
+		t.Errorf("unexpected MediaMTX args: %v", options.MediaMTXArgs)
+	}
+	if !filepath.IsAbs(options.PanelsFile) {
+		t.Errorf("expected a set path to be made absolute, got %v", options.PanelsFile)
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "seed")
+	os.WriteFile(secret, []byte(strings.Repeat("ab", 32)+"\n"), 0600)
+
+	t.Setenv("GONOVON_TITLE", "from env")
+	t.Setenv("GONOVON_RECORD_MAX_AGE", "24h")
+	t.Setenv("GONOVON_DVR_MINUTES", "5")
+	t.Setenv("GONOVON_MATURE", "true")
+	t.Setenv("GONOVON_TRANSCODERS", "720p30, 480p")
+	t.Setenv("GONOVON_TAGS", `["a,b", "c"]`)
+	t.Setenv("GONOVON_SEED_FILE", secret)
+
+	cfg := &Config{Title: "from file", Transcoders: []string{"1080p"}}
+	if err := applyEnvOverrides(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Title != "from env" || cfg.RecordMaxAge != "24h" || cfg.DvrMinutes != 5 || !cfg.Mature {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if !slices.Equal(cfg.Transcoders, []string{"720p30", "480p"}) || !slices.Equal(cfg.Tags, []string{"a,b", "c"}) {
+		t.Errorf("unexpected lists: %v %v", cfg.Transcoders, cfg.Tags)
+	}
+	if cfg.Seed != strings.Repeat("ab", 32) {
+		t.Errorf("expected the seed from the secret file, got %q", cfg.Seed)
+	}
+
+	t.Setenv("GONOVON_DVR_MINUTES", "five")
+	if err := applyEnvOverrides(cfg); err == nil || !strings.HasPrefix(err.Error(), "GONOVON_DVR_MINUTES") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestEnvNamesOfEveryConfigField(t *testing.T) {
+	expected := map[string]string{
+		"seed": "GONOVON_SEED", "keystore": "GONOVON_KEYSTORE", "title": "GONOVON_TITLE", "owner": "GONOVON_OWNER",
+		"transcoders": "GONOVON_TRANSCODERS", "category": "GONOVON_CATEGORY", "tags": "GONOVON_TAGS",
+		"language": "GONOVON_LANGUAGE", "mature": "GONOVON_MATURE", "record": "GONOVON_RECORD",
+		"recordPath": "GONOVON_RECORD_PATH", "recordMaxAge": "GONOVON_RECORD_MAX_AGE",
+		"recordMaxSizeMB": "GONOVON_RECORD_MAX_SIZE_MB", "reruns": "GONOVON_RERUNS", "rerunLoop": "GONOVON_RERUN_LOOP",
+		"dvrMinutes": "GONOVON_DVR_MINUTES", "dvrPath": "GONOVON_DVR_PATH", "moderators": "GONOVON_MODERATORS",
+		"clipPath": "GONOVON_CLIP_PATH", "relay": "GONOVON_RELAY", "ingest": "GONOVON_INGEST",
+		"srtPassphrase": "GONOVON_SRT_PASSPHRASE", "source": "GONOVON_SOURCE",
+		"sourceOnDemand": "GONOVON_SOURCE_ON_DEMAND", "chatLog": "GONOVON_CHAT_LOG",
+		"donationLedger": "GONOVON_DONATION_LEDGER",
+	}
+
+	configType := reflect.TypeOf(Config{})
+	if configType.NumField() != len(expected) {
+		t.Errorf("expected %v config fields, got %v, add the new ones here", len(expected), configType.NumField())
+	}
+	for i := 0; i < configType.NumField(); i++ {
+		jsonName, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+		if name := envName(jsonName); name != expected[jsonName] {
+			t.Errorf("%v: expected %v, got %v", jsonName, expected[jsonName], name)
+		}
+	}
+
+	for jsonName, name := range map[string]string{"httpURL": "GONOVON_HTTP_URL", "maxMB": "GONOVON_MAX_MB", "APIKey": "GONOVON_API_KEY"} {
+		if envName(jsonName) != name {
+			t.Errorf("%v: expected %v, got %v", jsonName, name, envName(jsonName))
+		}
+	}
+}
+
+func TestRelativeSeedFileSurvivesEnteringDataDir(t *testing.T) {
+	start := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(start)
+	t.Cleanup(func() { os.Chdir(wd) })
+	os.WriteFile("seed", []byte(strings.Repeat("cd", 32)), 0600)
+
+	old := hostOptions
+	t.Cleanup(func() { hostOptions = old })
+	t.Setenv("GONOVON_SEED_FILE", "seed")
+	options, err := parseHostOptions([]string{"--data-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostOptions = options
+	if err := hostOptions.enterDataDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	if err := applyConfigOverrides(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Seed != strings.Repeat("cd", 32) {
+		t.Errorf("expected the seed file relative to where gonovon was started, got %q", cfg.Seed)
+	}
+}
@@ -1,14 +1,39 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
+	"sync/atomic"
 )
 
-var panels string = "[]"
+const PANELS_FILE = "panels.json"
 
-func loadPanels() {
-	bin, err := os.ReadFile("panels.json")
+// panels is the content of the panels file, a reload replaces it.
+var panels atomic.Pointer[string]
+
+// currentPanels returns the panels sent to viewers, an empty list until the panels file is loaded.
+func currentPanels() string {
+	if p := panels.Load(); p != nil {
+		return *p
+	}
+	return "[]"
+}
+
+func loadPanels(panelsFile string) {
+	newPanels, err := readPanels(panelsFile)
 	if err == nil {
-		panels = string(bin)
+		panels.Store(&newPanels)
+	}
+}
+
+// readPanels reads a panels file, it has to be valid JSON since it is sent to viewers as is.
+func readPanels(panelsFile string) (string, error) {
+	bin, err := os.ReadFile(panelsFile)
+	if err != nil {
+		return "", err
+	}
+	if !json.Valid(bin) {
+		return "", errInvalidPanels
 	}
+	return string(bin), nil
 }
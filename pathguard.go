@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// MEDIAMTX_API is the MediaMTX control API, the generated mediamtx.yml enables it on localhost only.
+const MEDIAMTX_API = "http://127.0.0.1:9997"
+const PATH_GUARD_INTERVAL = 2 * time.Second
+
+// The kick endpoint of every publisher type, static sources configured in mediamtx.yml can't be kicked.
+var kickEndpoints = map[string]string{
+	"rtmpConn":      "rtmpconns",
+	"rtmpsConn":     "rtmpsconns",
+	"rtspSession":   "rtspsessions",
+	"rtspsSession":  "rtspssessions",
+	"srtConn":       "srtconns",
+	"webrtcSession": "webrtcsessions",
+}
+
+// mediaMTXPath is an entry of the MediaMTX /v3/paths/list answer.
+type mediaMTXPath struct {
+	Name   string `json:"name"`
+	Source *struct {
+		Type string `json:"type"`
+		Id   string `json:"id"`
+	} `json:"source"`
+	Ready     bool       `json:"ready"`
+	ReadyTime *time.Time `json:"readyTime"`
+}
+
+// guardPublishingPaths keeps a single MediaMTX path publishing. MediaMTX hands gonovon the segments of every path
+// without saying which path they belong to, so a second publisher would mix its segments into the channel. The
+// path that has been publishing the longest keeps the channel, publishers of other paths are kicked.
+func guardPublishingPaths(api string) {
+	log.Println("Keeping a single MediaMTX path publishing through the MediaMTX API at", api)
+	go func() {
+		warned := false
+		for range time.Tick(PATH_GUARD_INTERVAL) {
+			paths, err := readyPaths(api)
+			if err != nil {
+				if !warned {
+					log.Println("MediaMTX API unavailable, publishing to more than one path mixes the streams:", err)
+					warned = true
+				}
+				continue
+			}
+			warned = false
+
+			keep, extra := splitPublishingPaths(paths)
+			for _, path := range extra {
+				log.Println("Only one channel per gonovon is supported, path", path.Name, "would mix with", keep.Name)
+				if err := kickPublisher(api, path); err != nil {
+					log.Println("could not kick the publisher of", path.Name, err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func readyPaths(api string) ([]mediaMTXPath, error) {
+	resp, err := http.Get(api + "/v3/paths/list?itemsPerPage=1000")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("paths list: %v", resp.Status)
+	}
+
+	var list struct {
+		Items []mediaMTXPath `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	ready := make([]mediaMTXPath, 0, len(list.Items))
+	for _, path := range list.Items {
+		if path.Ready {
+			ready = append(ready, path)
+		}
+	}
+	return ready, nil
+}
+
+// splitPublishingPaths returns the path that has been ready the longest and every other ready path.
+func splitPublishingPaths(paths []mediaMTXPath) (mediaMTXPath, []mediaMTXPath) {
+	if len(paths) == 0 {
+		return mediaMTXPath{}, nil
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		a, b := paths[i].ReadyTime, paths[j].ReadyTime
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if !a.Equal(*b) {
+			return a.Before(*b)
+		}
+		return paths[i].Name < paths[j].Name
+	})
+	return paths[0], paths[1:]
+}
+
+func kickPublisher(api string, path mediaMTXPath) error {
+	if path.Source == nil {
+		return fmt.Errorf("no publisher")
+	}
+	endpoint, ok := kickEndpoints[path.Source.Type]
+	if !ok {
+		return fmt.Errorf("%v sources can't be kicked, remove the source from mediamtx.yml", path.Source.Type)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%v/v3/%v/kick/%v", api, endpoint, path.Source.Id), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kick: %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPathGuardKicksLaterPublishers(t *testing.T) {
+	var mutex sync.Mutex
+	kicked := make([]string, 0)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/paths/list" {
+			w.Write([]byte(`{"itemCount": 3, "pageCount": 1, "items": [
+				{"name": "second", "source": {"type": "rtmpConn", "id": "b"}, "ready": true, "readyTime": "2024-01-01T00:00:10Z"},
+				{"name": "idle", "source": null, "ready": false, "readyTime": null},
+				{"name": "first", "source": {"type": "srtConn", "id": "a"}, "ready": true, "readyTime": "2024-01-01T00:00:00Z"}
+			]}`))
+			return
+		}
+		mutex.Lock()
+		kicked = append(kicked, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+	}))
+	defer api.Close()
+
+	paths, err := readyPaths(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep, extra := splitPublishingPaths(paths)
+	if keep.Name != "first" || len(extra) != 1 || extra[0].Name != "second" {
+		b, _ := json.Marshal(extra)
+		t.Fatalf("expected to keep first and kick second, kept %v, kicking %s", keep.Name, b)
+	}
+
+	if err := kickPublisher(api.URL, extra[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(kicked) != 1 || kicked[0] != "POST /v3/rtmpconns/kick/b" {
+		t.Errorf("unexpected kick requests: %v", kicked)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SOURCE_SCHEMES are the URL schemes a pull source can use, http and https are HLS playlists.
+var SOURCE_SCHEMES = []string{"rtsp", "rtsps", "rtmp", "rtmps", "http", "https", "srt"}
+
+const SOURCE_MIN_BACKOFF = time.Second
+const SOURCE_MAX_BACKOFF = time.Minute
+
+// A pull that kept running this long resets the backoff.
+const SOURCE_STABLE_AFTER = 30 * time.Second
+
+// A pull that produced no segment for this long is restarted.
+const SOURCE_STALL_TIMEOUT = 15 * time.Second
+
+// An on-demand pull stops this long after the last viewer left.
+const SOURCE_ON_DEMAND_CLOSE_AFTER = time.Minute
+
+const SOURCE_POLL_INTERVAL = 200 * time.Millisecond
+
+const (
+	SOURCE_IDLE       = "idle"
+	SOURCE_CONNECTING = "connecting"
+	SOURCE_RECEIVING  = "receiving"
+	SOURCE_BACKOFF    = "backoff"
+)
+
+const (
+	SOURCE_ALWAYS_ON = "always-on"
+	SOURCE_ON_DEMAND = "on-demand"
+)
+
+var pullSource *PullSource
+
+// PullSource restreams a URL instead of waiting for a publisher. ffmpeg cuts the source into MPEG-TS segments that
+// go through publishTSPart like the ones MediaMTX hands over.
+type PullSource struct {
+	url      string
+	onDemand bool
+
+	state      string
+	since      time.Time
+	reconnects int
+	lastError  string
+	retryAt    time.Time
+	lastDemand time.Time
+
+	stop  chan struct{}
+	mutex sync.Mutex
+}
+
+// SourceStatus is the state of the pull source, shown to owners and moderators in the channel info.
+type SourceStatus struct {
+	Url        string    `json:"url"`
+	Mode       string    `json:"mode"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Reconnects int       `json:"reconnects"`
+	LastError  string    `json:"lastError,omitempty"`
+	RetryAt    time.Time `json:"retryAt,omitempty"`
+}
+
+// NewPullSource returns nil when no source is configured, all methods are no-ops on nil.
+func NewPullSource(config *Config) *PullSource {
+	if config.Source == "" {
+		return nil
+	}
+	return &PullSource{
+		url:      config.Source,
+		onDemand: config.SourceOnDemand,
+		state:    SOURCE_IDLE,
+		since:    time.Now(),
+		stop:     make(chan struct{}),
+	}
+}
+
+// validateSource checks the pull source URL.
+func validateSource(c *Config) []error {
+	problems := make([]error, 0)
+	if c.Source == "" {
+		if c.SourceOnDemand {
+			problems = append(problems, fmt.Errorf("sourceOnDemand: needs a source"))
+		}
+		return problems
+	}
+
+	u, err := url.Parse(c.Source)
+	if err != nil || u.Host == "" || !slices.Contains(SOURCE_SCHEMES, u.Scheme) {
+		problems = append(problems, fmt.Errorf("source: not a %v URL", strings.Join(SOURCE_SCHEMES, ", ")))
+	}
+	if c.Relay != "" {
+		problems = append(problems, fmt.Errorf("source: can't be used together with relay"))
+	}
+	return problems
+}
+
+// Start pulls the source in the background until Stop.
+func (p *PullSource) Start() {
+	if p == nil {
+		return
+	}
+
+	log.Println("Pulling source", redactURL(p.url), p.mode())
+
+	go p.run()
+}
+
+// Stop ends the pull, a running ffmpeg is killed.
+func (p *PullSource) Stop() {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+}
+
+// Demand starts an on-demand pull, viewers opening the channel call it.
+func (p *PullSource) Demand() {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lastDemand = time.Now()
+}
+
+// Status returns the current state of the pull.
+func (p *PullSource) Status() *SourceStatus {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := &SourceStatus{
+		Url:        redactURL(p.url),
+		Mode:       p.mode(),
+		State:      p.state,
+		Since:      p.since,
+		Reconnects: p.reconnects,
+		LastError:  p.lastError,
+	}
+	if p.state == SOURCE_BACKOFF {
+		status.RetryAt = p.retryAt
+	}
+	return status
+}
+
+func (p *PullSource) mode() string {
+	if p.onDemand {
+		return SOURCE_ON_DEMAND
+	}
+	return SOURCE_ALWAYS_ON
+}
+
+func (p *PullSource) setState(state string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.state != state {
+		p.state = state
+		p.since = time.Now()
+	}
+}
+
+// wanted reports whether the source has to be pulled, on-demand sources only while someone is watching.
+func (p *PullSource) wanted() bool {
+	if !p.onDemand {
+		return true
+	}
+
+	//viewers has its own lock, p.mutex only protects lastDemand
+	if viewers.Count() > 0 {
+		return true
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return time.Since(p.lastDemand) < SOURCE_ON_DEMAND_CLOSE_AFTER
+}
+
+func (p *PullSource) run() {
+	backoff := SOURCE_MIN_BACKOFF
+	for {
+		if !p.wanted() {
+			p.setState(SOURCE_IDLE)
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+
+		start := time.Now()
+		err := p.pull()
+
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		if time.Since(start) > SOURCE_STABLE_AFTER {
+			backoff = SOURCE_MIN_BACKOFF
+		}
+		if err == nil {
+			//An on-demand pull that was no longer wanted
+			continue
+		}
+
+		log.Println("Source pull stopped, retrying in", backoff, err.Error())
+		p.mutex.Lock()
+		p.reconnects++
+		p.lastError = err.Error()
+		p.retryAt = time.Now().Add(backoff)
+		p.mutex.Unlock()
+		p.setState(SOURCE_BACKOFF)
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, SOURCE_MAX_BACKOFF)
+	}
+}
+
+// pull runs ffmpeg until it fails, stalls, the source is no longer wanted or the pull is stopped, and publishes
+// every segment it finishes.
+func (p *PullSource) pull() error {
+	p.setState(SOURCE_CONNECTING)
+
+	dir, err := os.MkdirTemp("", "gonovon-source")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	listFile := filepath.Join(dir, "segments.txt")
+	kill, exited, err := commands.Start("ffmpeg", sourceArgs(p.url, dir, listFile))
+	if err != nil {
+		return err
+	}
+	defer kill()
+
+	published := 0
+	lastSegment := time.Now()
+	ticker := time.NewTicker(SOURCE_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return nil
+		case err := <-exited:
+			if err == nil {
+				err = fmt.Errorf("source ended")
+			}
+			return err
+		case <-ticker.C:
+		}
+
+		segments, err := readSegmentList(listFile)
+		if err != nil {
+			return err
+		}
+		for ; published < len(segments); published++ {
+			segment, err := os.ReadFile(filepath.Join(dir, segments[published]))
+			if os.IsNotExist(err) {
+				//The list is being written, read it again next time
+				break
+			}
+			if err != nil {
+				return err
+			}
+			os.Remove(filepath.Join(dir, segments[published]))
+
+			p.setState(SOURCE_RECEIVING)
+			publishTSPart(segment)
+			lastSegment = time.Now()
+		}
+
+		if time.Since(lastSegment) > SOURCE_STALL_TIMEOUT {
+			return fmt.Errorf("no segment for %v", SOURCE_STALL_TIMEOUT)
+		}
+		if !p.wanted() {
+			log.Println("No viewers, stopping the on-demand source")
+			return nil
+		}
+	}
+}
+
+// sourceArgs makes ffmpeg cut the source into one second MPEG-TS segments, without re-encoding the video. Audio is
+// converted to AAC, IP cameras often send audio that MPEG-TS can't hold.
+func sourceArgs(source string, dir string, listFile string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if strings.HasPrefix(source, "rtsp") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	return append(args, "-i", source,
+		"-map", "0:v:0", "-map", "0:a:0?", "-c:v", "copy", "-c:a", "aac",
+		"-f", "segment", "-segment_time", "1", "-segment_format", "mpegts",
+		"-segment_list", listFile, "-segment_list_type", "flat",
+		filepath.Join(dir, "%d.ts"))
+}
+
+// readSegmentList returns the finished segments ffmpeg listed so far.
+func readSegmentList(listFile string) ([]string, error) {
+	f, err := os.Open(listFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	segments := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			segments = append(segments, filepath.Base(line))
+		}
+	}
+	return segments, scanner.Err()
+}
+
+// redactURL hides the password of a source URL.
+func redactURL(source string) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Redacted()
+}
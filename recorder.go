@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const RECORDING_PLAYLIST = "index.m3u8"
+
+// RECORDING_ENDED is the file in a session directory holding the time the session ended, the directory itself
+// carries the time it started.
+const RECORDING_ENDED = "ended"
+
+// Playlists are written every RECORD_FLUSH_INTERVAL instead of on every segment, retention runs every
+// RECORD_RETENTION_INTERVAL so a 24/7 stream is pruned too.
+const RECORD_FLUSH_INTERVAL = 10 * time.Second
+const RECORD_RETENTION_INTERVAL = time.Minute
+
+// The duration of the newest segment is only known once the next one arrives, until then it is estimated.
+const RECORD_ESTIMATED_DURATION = 2 * time.Second
+
+var recorder *Recorder
+
+// Recorder writes the exact segments that are broadcasted to a session directory, one HLS playlist per quality level.
+type Recorder struct {
+	path    string
+	maxAge  time.Duration
+	maxSize int64
+
+	session string
+	levels  map[string]*recordLevel
+	mutex   sync.Mutex
+}
+
+// recordLevel holds the playlist entries of a single quality level in the current session, removed counts the
+// entries retention took from the start of the playlist.
+type recordLevel struct {
+	entries []recordEntry
+	removed int
+	dirty   bool
+}
+
+type recordEntry struct {
+	segmentId int
+	received  time.Time
+	duration  time.Duration
+	size      int64
+}
+
+// NewRecorder creates a recorder from the configuration, returns nil when recording is disabled.
+func NewRecorder(config *Config) *Recorder {
+	if !config.Record {
+		return nil
+	}
+
+	path := config.RecordPath
+	if path == "" {
+		path = "./recordings"
+	}
+
+	maxAge, err := time.ParseDuration(config.RecordMaxAge)
+	if err != nil && config.RecordMaxAge != "" {
+		log.Println("invalid recordMaxAge in config, recordings will not expire by age:", config.RecordMaxAge)
+	}
+
+	return &Recorder{
+		path:    path,
+		maxAge:  maxAge,
+		maxSize: int64(config.RecordMaxSizeMB) * 1024 * 1024,
+	}
+}
+
+// Start writes the playlists and enforces the retention limits in the background.
+func (r *Recorder) Start() {
+	if r == nil {
+		return
+	}
+
+	go func() {
+		flush := time.NewTicker(RECORD_FLUSH_INTERVAL)
+		retention := time.NewTicker(RECORD_RETENTION_INTERVAL)
+		for {
+			select {
+			case <-flush.C:
+				r.flushPlaylists()
+			case <-retention.C:
+				r.enforceRetention()
+			}
+		}
+	}()
+}
+
+// StartSession begins a new recording session directory, ending the previous one.
+func (r *Recorder) StartSession() {
+	if r == nil {
+		return
+	}
+
+	r.EndSession()
+
+	r.mutex.Lock()
+	r.session = filepath.Join(r.path, time.Now().Format("2006-01-02_15-04-05"))
+	r.levels = make(map[string]*recordLevel)
+	r.mutex.Unlock()
+
+	log.Println("Recording session to:", r.session)
+	go r.enforceRetention()
+}
+
+// EndSession finalizes the playlists of the current session.
+func (r *Recorder) EndSession() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.session == "" {
+		return
+	}
+
+	for name, level := range r.levels {
+		if err := r.writePlaylist(name, level, true); err != nil {
+			log.Println("error finalizing recording playlist", err.Error())
+		}
+	}
+
+	//The last source segment ends the recorded content, the session ends now when nothing was recorded
+	ended := time.Now()
+	if source, ok := r.levels[qualityLevelName(nil)]; ok && len(source.entries) > 0 {
+		ended = source.entries[len(source.entries)-1].received
+	}
+	if _, err := os.Stat(r.session); err == nil {
+		if err := os.WriteFile(filepath.Join(r.session, RECORDING_ENDED), []byte(ended.Format(time.RFC3339Nano)), 0644); err != nil {
+			log.Println("error writing recording end time", err.Error())
+		}
+	}
+
+	log.Println("Recording session ended:", r.session)
+	r.session = ""
+	r.levels = nil
+}
+
+// WriteSegment stores a published segment of a quality level and adds it to the level playlist, received is when
+// the segment arrived and gives the duration of the segment before it.
+func (r *Recorder) WriteSegment(levelName string, segmentId int, segment []byte, received time.Time) {
+	if r == nil || len(segment) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.session == "" {
+		return
+	}
+
+	levelDir := filepath.Join(r.session, levelName)
+	if err := os.MkdirAll(levelDir, 0755); err != nil {
+		log.Println("error creating recording directory", err.Error())
+		return
+	}
+
+	err := os.WriteFile(filepath.Join(levelDir, segmentFileName(segmentId)), segment, 0644)
+	if err != nil {
+		log.Println("error writing recording segment", err.Error())
+		return
+	}
+
+	level, ok := r.levels[levelName]
+	if !ok {
+		level = &recordLevel{}
+		r.levels[levelName] = level
+	}
+
+	//Segments are published concurrently, keep the playlist ordered by segment id, usually this is an append
+	i := sort.Search(len(level.entries), func(i int) bool { return level.entries[i].segmentId > segmentId })
+	level.entries = slices.Insert(level.entries, i, recordEntry{segmentId: segmentId, received: received, size: int64(len(segment))})
+	level.setDuration(i - 1)
+	level.setDuration(i)
+	level.dirty = true
+}
+
+// setDuration sets the duration of an entry from the arrival of the next one.
+func (l *recordLevel) setDuration(i int) {
+	if i < 0 {
+		return
+	}
+	if i+1 < len(l.entries) {
+		l.entries[i].duration = l.entries[i+1].received.Sub(l.entries[i].received)
+	} else if i > 0 {
+		l.entries[i].duration = l.entries[i-1].duration
+	} else {
+		l.entries[i].duration = RECORD_ESTIMATED_DURATION
+	}
+}
+
+// flushPlaylists writes the playlists that changed since the last flush.
+func (r *Recorder) flushPlaylists() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.session == "" {
+		return
+	}
+	for name, level := range r.levels {
+		if !level.dirty {
+			continue
+		}
+		if err := r.writePlaylist(name, level, false); err != nil {
+			log.Println("error writing recording playlist", err.Error())
+			continue
+		}
+		level.dirty = false
+	}
+}
+
+func (r *Recorder) writePlaylist(levelName string, level *recordLevel, ended bool) error {
+	targetDuration := 1.0
+	for _, entry := range level.entries {
+		targetDuration = math.Max(targetDuration, math.Ceil(entry.duration.Seconds()))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:3\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%.0f\n", targetDuration))
+	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", level.removed))
+	if ended {
+		sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	} else if level.removed == 0 {
+		//An event playlist can't lose entries, once retention removed some it is a plain live playlist
+		sb.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+
+	for _, entry := range level.entries {
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", entry.duration.Seconds(), segmentFileName(entry.segmentId)))
+	}
+
+	if ended {
+		sb.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return os.WriteFile(filepath.Join(r.session, levelName, RECORDING_PLAYLIST), []byte(sb.String()), 0644)
+}
+
+// enforceRetention removes the oldest finished sessions until the age and size limits are met, then the oldest
+// segments of the current session.
+func (r *Recorder) enforceRetention() {
+	if r.maxAge <= 0 && r.maxSize <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(r.path)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	current := r.session
+	r.mutex.Unlock()
+
+	type sessionDir struct {
+		path  string
+		ended time.Time
+		size  int64
+	}
+
+	sessions := make([]sessionDir, 0)
+	var totalSize int64
+	for _, entry := range entries {
+		path := filepath.Join(r.path, entry.Name())
+		if !entry.IsDir() || path == current {
+			continue
+		}
+
+		size, ended := dirSize(path), sessionEnd(path)
+		totalSize += size
+		sessions = append(sessions, sessionDir{path: path, ended: ended, size: size})
+	}
+
+	//Oldest first
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ended.Before(sessions[j].ended)
+	})
+
+	for _, session := range sessions {
+		expired := r.maxAge > 0 && time.Since(session.ended) > r.maxAge
+		oversized := r.maxSize > 0 && totalSize > r.maxSize
+		if !expired && !oversized {
+			continue
+		}
+
+		if err := os.RemoveAll(session.path); err != nil {
+			log.Println("error removing recording", session.path, err.Error())
+			continue
+		}
+		totalSize -= session.size
+		log.Println("Removed recording:", session.path)
+	}
+
+	r.pruneSession(r.maxSize - totalSize)
+}
+
+// pruneSession removes the oldest segments of the current session, of every quality level, while they are older
+// than the age limit or the session is larger than maxSize. The newest segment is always kept.
+func (r *Recorder) pruneSession(maxSize int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	source, ok := r.levels[qualityLevelName(nil)]
+	if r.session == "" || !ok {
+		return
+	}
+
+	var size int64
+	for _, level := range r.levels {
+		for _, entry := range level.entries {
+			size += entry.size
+		}
+	}
+
+	removed := 0
+	for len(source.entries) > 1 {
+		oldest := source.entries[0]
+		expired := r.maxAge > 0 && time.Since(oldest.received) > r.maxAge
+		oversized := r.maxSize > 0 && size > maxSize
+		if !expired && !oversized {
+			break
+		}
+
+		for name, level := range r.levels {
+			for len(level.entries) > 0 && level.entries[0].segmentId <= oldest.segmentId {
+				entry := level.entries[0]
+				os.Remove(filepath.Join(r.session, name, segmentFileName(entry.segmentId)))
+				size -= entry.size
+				level.entries = level.entries[1:]
+				level.removed++
+				level.dirty = true
+			}
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Println("Removed", removed, "segments from the start of recording:", r.session)
+	}
+}
+
+// sessionEnd returns the time a finished session ended. Sessions that were not ended, because the host crashed,
+// ended when their newest file was written.
+func sessionEnd(path string) time.Time {
+	if data, err := os.ReadFile(filepath.Join(path, RECORDING_ENDED)); err == nil {
+		if ended, err := time.Parse(time.RFC3339Nano, string(data)); err == nil {
+			return ended
+		}
+	}
+
+	var newest time.Time
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func segmentFileName(segmentId int) string {
+	return fmt.Sprintf("%08d.ts", segmentId)
+}
+
+// qualityLevelName is the directory name of a quality level in a recording session.
+func qualityLevelName(transcode *Transcode) string {
+	if transcode == nil {
+		return "source"
+	}
+	return fmt.Sprintf("%dp%d", transcode.Resolution, transcode.Framerate)
+}
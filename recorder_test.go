@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderRetentionRemovesOldSessions(t *testing.T) {
+	dir := t.TempDir()
+	r := &Recorder{path: dir, maxAge: time.Hour, maxSize: 2500}
+
+	//The sessions are written newest first, only the end time EndSession records tells their age
+	writeSession := func(name string, size int, age time.Duration) {
+		r.mutex.Lock()
+		r.session = filepath.Join(dir, name)
+		r.levels = make(map[string]*recordLevel)
+		r.mutex.Unlock()
+
+		r.WriteSegment(qualityLevelName(nil), 0, make([]byte, size), time.Now().Add(-age))
+		r.EndSession()
+	}
+	writeSession("newest", 1000, 10*time.Minute)
+	writeSession("older", 1000, 20*time.Minute)
+	writeSession("oldest", 1000, 30*time.Minute)
+	writeSession("expired", 10, 2*time.Hour)
+
+	r.enforceRetention()
+
+	for name, kept := range map[string]bool{"expired": false, "oldest": false, "older": true, "newest": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%v: expected kept %v", name, kept)
+		}
+	}
+}
+
+func TestRecorderRetentionPrunesCurrentSession(t *testing.T) {
+	r := &Recorder{path: t.TempDir(), maxSize: 350}
+	r.StartSession()
+
+	start := time.Now().Add(-time.Minute)
+	for id := 0; id < 5; id++ {
+		received := start.Add(time.Duration(id) * 2 * time.Second)
+		r.WriteSegment("source", id, make([]byte, 50), received)
+		r.WriteSegment("720p30", id, make([]byte, 25), received)
+	}
+
+	r.enforceRetention()
+	r.EndSession()
+
+	playlist, err := os.ReadFile(filepath.Join(onlySession(t, r.path), "source", RECORDING_PLAYLIST))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(playlist), "#EXT-X-MEDIA-SEQUENCE:1\n") || strings.Contains(string(playlist), segmentFileName(0)) {
+		t.Errorf("expected the oldest segment to be removed from the playlist:\n%v", string(playlist))
+	}
+
+	segments, err := readRecordingPlaylist(filepath.Join(onlySession(t, r.path), "720p30", RECORDING_PLAYLIST))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 4 || segments[0].file != segmentFileName(1) {
+		t.Errorf("expected the transcoded level to lose the same segment, got %+v", segments)
+	}
+	if _, err := os.Stat(filepath.Join(onlySession(t, r.path), "720p30", segmentFileName(0))); !os.IsNotExist(err) {
+		t.Error("expected the removed segment file to be deleted")
+	}
+	for _, segment := range segments {
+		if segment.duration != 2*time.Second {
+			t.Errorf("expected every segment to last until the next one arrived, got %v", segment.duration)
+		}
+	}
+
+	r = &Recorder{path: t.TempDir(), maxAge: time.Second}
+	r.StartSession()
+	r.WriteSegment("source", 0, make([]byte, 50), start)
+	r.WriteSegment("source", 1, make([]byte, 50), time.Now())
+	r.enforceRetention()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if entries := r.levels["source"].entries; len(entries) != 1 || entries[0].segmentId != 1 {
+		t.Errorf("expected the expired segment to be removed, got %+v", entries)
+	}
+}
+
+// onlySession returns the only session directory of a recording path.
+func onlySession(t *testing.T, path string) string {
+	t.Helper()
+
+	entries, err := os.ReadDir(path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one session, got %v %v", len(entries), err)
+	}
+	return filepath.Join(path, entries[0].Name())
+}
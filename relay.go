@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"gonovon/transport"
+	"gonovon/viewer"
+)
+
+const RELAY_SEGMENT_TIMEOUT = 3 * time.Second
+
+// The origin is lost when it sent nothing for RELAY_IDLE_TIMEOUT, the relay then reconnects every
+// RELAY_RECONNECT_INTERVAL until the origin broadcasts again.
+const RELAY_IDLE_TIMEOUT = 30 * time.Second
+const RELAY_RECONNECT_INTERVAL = 10 * time.Second
+
+var relay *Relay
+
+// Relay re-broadcasts the chunks of an origin host to our own viewers, one upstream viewer per quality level.
+type Relay struct {
+	origin  string
+	viewers []*viewer.Viewer
+	clients []transport.Transport
+	closed  bool
+
+	pending       map[int]*relaySegment
+	lastBroadcast int
+	mutex         sync.Mutex
+}
+
+// relaySegment collects the quality levels of one origin segment.
+type relaySegment struct {
+	levels    [][][]byte
+	received  int
+	firstSeen time.Time
+}
+
+// NewRelay creates a relay of the origin configured in config.json, returns nil when not relaying.
+func NewRelay(config *Config) *Relay {
+	if config.Relay == "" {
+		return nil
+	}
+
+	return &Relay{
+		origin:        config.Relay,
+		pending:       make(map[int]*relaySegment),
+		lastBroadcast: -1,
+	}
+}
+
+// Start joins the origin with a viewer for every quality level it offers and starts re-broadcasting.
+func (r *Relay) Start() error {
+	if err := r.connect(); err != nil {
+		return err
+	}
+
+	go r.flushStale()
+
+	return nil
+}
+
+// connect joins the origin, the viewers and their clients are closed again when joining fails.
+func (r *Relay) connect() (err error) {
+	viewers := make([]*viewer.Viewer, 0)
+	clients := make([]transport.Transport, 0)
+	defer func() {
+		if err != nil {
+			closeRelayViewers(viewers, clients)
+		}
+	}()
+	join := func(quality int) (*viewer.Viewer, error) {
+		client := createViewerClient()
+		clients = append(clients, client)
+		v := viewer.New(client, r.origin, &viewer.Config{Quality: quality, IdleTimeout: RELAY_IDLE_TIMEOUT})
+		viewers = append(viewers, v)
+		return v, v.Start()
+	}
+
+	source, err := join(0)
+	if err != nil {
+		return err
+	}
+
+	info, err := source.ChannelInfo()
+	if err != nil {
+		return err
+	}
+
+	if len(info.QualityLevels) > 0 {
+		relayed := &SourceInfo{
+			Resolution:  info.QualityLevels[0].Resolution,
+			Framerate:   info.QualityLevels[0].Framerate,
+			Transcoders: make([]Transcode, 0, len(info.QualityLevels)-1),
+		}
+		for _, level := range info.QualityLevels[1:] {
+			relayed.Transcoders = append(relayed.Transcoders, Transcode{Resolution: level.Resolution, Framerate: level.Framerate})
+		}
+		streamSource.Store(relayed)
+	}
+
+	for q := 1; q < len(info.QualityLevels); q++ {
+		if _, err := join(q); err != nil {
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return errors.New("relay is closed")
+	}
+	//A restarted origin numbers its segments from 0 again
+	r.pending = make(map[int]*relaySegment)
+	r.lastBroadcast = -1
+	r.viewers = viewers
+	r.clients = clients
+	r.mutex.Unlock()
+
+	log.Println("Relaying:", r.origin, "quality levels:", len(viewers))
+
+	for q, v := range viewers {
+		go r.receiveSegments(q, v)
+	}
+
+	//Chat and control messages of the origin reach us on the source quality viewer
+	go func() {
+		for msg := range source.Chat {
+			binary, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			publish(binary, false)
+		}
+	}()
+	go func() {
+		for text := range source.Text {
+			publishText(text)
+		}
+	}()
+	//The other levels get the same messages, they are discarded
+	for _, v := range viewers[1:] {
+		go func(v *viewer.Viewer) {
+			for range v.Chat {
+			}
+		}(v)
+		go func(v *viewer.Viewer) {
+			for range v.Text {
+			}
+		}(v)
+	}
+
+	return nil
+}
+
+// reconnect replaces the viewers of a lost origin, retrying until the origin can be joined or the relay is closed.
+func (r *Relay) reconnect() {
+	r.mutex.Lock()
+	viewers, clients := r.viewers, r.clients
+	r.viewers, r.clients = nil, nil
+	r.mutex.Unlock()
+	closeRelayViewers(viewers, clients)
+
+	for {
+		time.Sleep(RELAY_RECONNECT_INTERVAL)
+
+		r.mutex.Lock()
+		closed := r.closed
+		r.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		err := r.connect()
+		if err == nil {
+			log.Println("Relay reconnected to the origin")
+			return
+		}
+		log.Println("could not reconnect to origin, retrying in", RELAY_RECONNECT_INTERVAL, err.Error())
+	}
+}
+
+func closeRelayViewers(viewers []*viewer.Viewer, clients []transport.Transport) {
+	for _, v := range viewers {
+		v.Close()
+	}
+	for _, client := range clients {
+		if closer, ok := client.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// ForwardChat sends a chat message of one of our viewers to the origin.
+func (r *Relay) ForwardChat(msg *ChatMessage) {
+	r.mutex.Lock()
+	if len(r.viewers) == 0 {
+		r.mutex.Unlock()
+		return
+	}
+	source := r.viewers[0]
+	r.mutex.Unlock()
+
+	if err := source.SendChat(shortAddress(msg.Src) + ": " + msg.Text); err != nil {
+		log.Println("error forwarding chat to origin", err.Error())
+	}
+}
+
+func (r *Relay) receiveSegments(quality int, v *viewer.Viewer) {
+	for segment := range v.Segments {
+		r.add(quality, segment)
+	}
+	log.Println("Relay lost the origin on quality level:", quality)
+
+	//The source quality viewer of the current connection decides when to reconnect
+	r.mutex.Lock()
+	current := !r.closed && len(r.viewers) > 0 && r.viewers[0] == v
+	r.mutex.Unlock()
+	if quality == 0 && current {
+		go r.reconnect()
+	}
+}
+
+// add stores the chunks of a quality level and broadcasts the segment once all levels arrived.
+func (r *Relay) add(quality int, segment *viewer.Segment) {
+	r.mutex.Lock()
+	//Levels arriving after their segment was broadcasted are too late
+	if segment.Id <= r.lastBroadcast {
+		r.mutex.Unlock()
+		return
+	}
+
+	pending, ok := r.pending[segment.Id]
+	if !ok {
+		pending = &relaySegment{
+			levels:    make([][][]byte, len(r.viewers)),
+			firstSeen: time.Now(),
+		}
+		r.pending[segment.Id] = pending
+	}
+
+	//A reconnect can change the number of levels
+	if quality >= len(pending.levels) {
+		r.mutex.Unlock()
+		return
+	}
+
+	if pending.levels[quality] == nil {
+		pending.levels[quality] = segment.Chunks
+		pending.received++
+	}
+
+	complete := pending.received == len(pending.levels)
+	if complete {
+		delete(r.pending, segment.Id)
+	}
+	r.mutex.Unlock()
+
+	if complete {
+		r.broadcast(segment.Id, pending.levels)
+	}
+}
+
+// flushStale broadcasts segments that are missing quality levels after RELAY_SEGMENT_TIMEOUT.
+func (r *Relay) flushStale() {
+	for {
+		time.Sleep(time.Second)
+
+		stale := make(map[int][][][]byte)
+		r.mutex.Lock()
+		for id, pending := range r.pending {
+			if time.Since(pending.firstSeen) > RELAY_SEGMENT_TIMEOUT {
+				stale[id] = pending.levels
+				delete(r.pending, id)
+			}
+		}
+		r.mutex.Unlock()
+
+		for id, levels := range stale {
+			r.broadcast(id, levels)
+		}
+	}
+}
+
+// broadcast publishes the origin chunks unchanged, a missing level falls back on the closest available one.
+func (r *Relay) broadcast(id int, levels [][][]byte) {
+	for q := range levels {
+		if levels[q] != nil {
+			continue
+		}
+		for fallback := 1; fallback < len(levels); fallback++ {
+			if q-fallback >= 0 && levels[q-fallback] != nil {
+				levels[q] = levels[q-fallback]
+				break
+			}
+			if q+fallback < len(levels) && levels[q+fallback] != nil {
+				levels[q] = levels[q+fallback]
+				break
+			}
+		}
+	}
+
+	r.mutex.Lock()
+	r.lastBroadcast = max(r.lastBroadcast, id)
+	r.mutex.Unlock()
+
+	//Keep our segment id in line with the origin, viewers use it to sync after a quality switch.
+	segmentId.Store(int64(id + 1))
+	lastRtmpSegment.Store(time.Now().UnixNano())
+
+	log.Println("Relaying -", "viewers:", viewers.Count(), "segment:", id)
+	broadcastChunks(id, levels)
+}
+
+// Close disconnects the upstream viewers from the origin.
+func (r *Relay) Close() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.closed = true
+	viewers, clients := r.viewers, r.clients
+	r.viewers, r.clients = nil, nil
+	r.mutex.Unlock()
+
+	closeRelayViewers(viewers, clients)
+}
+
+// Origin returns the address of the relayed host.
+func (r *Relay) Origin() string {
+	if r == nil {
+		return ""
+	}
+	return r.origin
+}
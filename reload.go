@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var errInvalidPanels = errors.New("panels file is not valid JSON")
+
+// Editors save in several writes, a reload waits until the file has been quiet this long.
+const RELOAD_DEBOUNCE = 250 * time.Millisecond
+
+// transcodersChanged makes publishTSPart rebuild the transcoder ladder at the next segment boundary.
+var transcodersChanged atomic.Bool
+
+// forceResubscribe makes maintainStream resubscribe without waiting for the metadata refresh interval.
+var forceResubscribe atomic.Bool
+
+// watchConfig reloads the config and panels files when they change. The directories are watched instead of the
+// files, editors often replace a file instead of writing to it.
+func watchConfig(configFile string, panelsFile string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("error watching config files, reloading is disabled", err.Error())
+		return
+	}
+
+	for _, dir := range []string{filepath.Dir(configFile), filepath.Dir(panelsFile)} {
+		if err := watcher.Add(dir); err != nil {
+			log.Println("error watching", dir, err.Error())
+		}
+	}
+
+	go func() {
+		pending := make(map[string]*time.Timer)
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+
+				var reload func()
+				switch filepath.Clean(event.Name) {
+				case filepath.Clean(configFile):
+					reload = func() { reloadConfig(configFile) }
+				case filepath.Clean(panelsFile):
+					reload = func() { reloadPanels(panelsFile) }
+				default:
+					continue
+				}
+
+				if timer, ok := pending[event.Name]; ok {
+					timer.Stop()
+				}
+				pending[event.Name] = time.AfterFunc(RELOAD_DEBOUNCE, reload)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("error watching config files", err.Error())
+			}
+		}
+	}()
+}
+
+// reloadConfig applies a changed config file, settings that only take effect on startup keep their old values.
+func reloadConfig(configFile string) {
+	newConfig, err := readConfig(configFile)
+	if err != nil {
+		log.Println("Config reload rejected, keeping the current config:", err)
+		return
+	}
+	if problems := newConfig.Validate(); len(problems) > 0 {
+		log.Println("Config reload rejected, keeping the current config:", errors.Join(problems...))
+		return
+	}
+
+	old := currentConfig()
+	if restartOnly := keepRestartOnlySettings(old, newConfig); len(restartOnly) > 0 {
+		log.Println("Config reload: restart to apply", restartOnly)
+	}
+
+	changed := make([]string, 0)
+	if newConfig.Title != old.Title || newConfig.Category != old.Category || newConfig.Language != old.Language ||
+		newConfig.Mature != old.Mature || !slices.Equal(newConfig.Tags, old.Tags) {
+		changed = append(changed, "metadata")
+		forceResubscribe.Store(true)
+	}
+	if newConfig.Owner != old.Owner {
+		changed = append(changed, "owner")
+	}
+	if !slices.Equal(newConfig.Moderators, old.Moderators) {
+		changed = append(changed, "moderators")
+	}
+	if !slices.Equal(newConfig.Transcoders, old.Transcoders) {
+		changed = append(changed, "transcoders")
+		transcodersChanged.Store(true)
+	}
+
+	hostConfig.Store(newConfig)
+
+	if len(changed) == 0 {
+		log.Println("Config reloaded, nothing changed")
+		return
+	}
+	log.Println("Config reloaded, changed:", changed)
+}
+
+// keepRestartOnlySettings copies the settings that are only read on startup from old to new, and returns the
+// ones that changed.
+func keepRestartOnlySettings(old *Config, new *Config) []string {
+	changed := make([]string, 0)
+	//The seed unlocked from the keystore is only in memory
+	if (new.Seed != "" && new.Seed != old.Seed) || new.Keystore != old.Keystore {
+		changed = append(changed, "seed")
+	}
+	new.Seed, new.Keystore = old.Seed, old.Keystore
+	if new.Relay != old.Relay {
+		changed = append(changed, "relay")
+		new.Relay = old.Relay
+	}
+	if new.Record != old.Record || new.RecordPath != old.RecordPath || new.RecordMaxAge != old.RecordMaxAge ||
+		new.RecordMaxSizeMB != old.RecordMaxSizeMB {
+		changed = append(changed, "record")
+		new.Record, new.RecordPath, new.RecordMaxAge, new.RecordMaxSizeMB = old.Record, old.RecordPath, old.RecordMaxAge, old.RecordMaxSizeMB
+	}
+	if new.DvrMinutes != old.DvrMinutes || new.DvrPath != old.DvrPath {
+		changed = append(changed, "dvr")
+		new.DvrMinutes, new.DvrPath = old.DvrMinutes, old.DvrPath
+	}
+	if !slices.Equal(new.Ingest, old.Ingest) || new.SrtPassphrase != old.SrtPassphrase {
+		changed = append(changed, "ingest")
+		new.Ingest, new.SrtPassphrase = old.Ingest, old.SrtPassphrase
+	}
+	if new.Source != old.Source || new.SourceOnDemand != old.SourceOnDemand {
+		changed = append(changed, "source")
+		new.Source, new.SourceOnDemand = old.Source, old.SourceOnDemand
+	}
+	if new.ChatLog != old.ChatLog || new.DonationLedger != old.DonationLedger {
+		changed = append(changed, "logs")
+		new.ChatLog, new.DonationLedger = old.ChatLog, old.DonationLedger
+	}
+	return changed
+}
+
+func reloadPanels(panelsFile string) {
+	newPanels, err := readPanels(panelsFile)
+	if err != nil {
+		log.Println("Panels reload rejected, keeping the current panels:", err)
+		return
+	}
+	if newPanels == currentPanels() {
+		return
+	}
+
+	panels.Store(&newPanels)
+	log.Println("Panels reloaded")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	old := currentConfig()
+	defer hostConfig.Store(old)
+	seed := strings.Repeat("11", 32)
+	hostConfig.Store(&Config{Seed: seed, Title: "test", Transcoders: []string{"720p30"}})
+	transcodersChanged.Store(false)
+	owner := mustTransport(t).Address()
+
+	//Messages are handled while the config is reloaded
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				roleOf(owner)
+				subscriptionMetadata()
+			}
+		}
+	}()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"seed": "` + strings.Repeat("22", 32) + `", "title": "renamed", "owner": "` + owner + `", "transcoders": ["480p"]}`)
+	reloadConfig(configFile)
+
+	config := currentConfig()
+	if config.Title != "renamed" || config.Owner != owner {
+		t.Errorf("expected title and owner to be applied: %+v", config)
+	}
+	if config.Seed != seed {
+		t.Errorf("expected the seed to need a restart, got %v", config.Seed)
+	}
+	if !transcodersChanged.Load() || len(config.Transcoders) != 1 || config.Transcoders[0] != "480p" {
+		t.Errorf("expected the transcoder ladder to change at the next segment: %v", config.Transcoders)
+	}
+
+	applied := config
+	for _, invalid := range []string{`{"title": `, `{"seed": "` + seed + `", "transcoders": ["hd"]}`, `{"seed": "` + seed + `", "owner": "NKN"}`} {
+		write(invalid)
+		reloadConfig(configFile)
+		if currentConfig() != applied {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestReloadPanelsRejectsInvalidJSON(t *testing.T) {
+	old := panels.Load()
+	defer panels.Store(old)
+	panels.Store(nil)
+
+	panelsFile := filepath.Join(t.TempDir(), "panels.json")
+	os.WriteFile(panelsFile, []byte(`[{"title": "about"}`), 0600)
+	reloadPanels(panelsFile)
+	if currentPanels() != "[]" {
+		t.Errorf("expected invalid panels to be rejected, got %v", currentPanels())
+	}
+
+	os.WriteFile(panelsFile, []byte(`[{"title": "about"}]`), 0600)
+	reloadPanels(panelsFile)
+	if currentPanels() != `[{"title": "about"}]` {
+		t.Errorf("expected panels to be reloaded, got %v", currentPanels())
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MODE_LIVE    = "live"
+	MODE_RERUN   = "rerun"
+	MODE_OFFLINE = "offline"
+)
+
+var reruns *Reruns
+
+// Reruns broadcasts recorded sessions at real time pace while the channel is not live.
+type Reruns struct {
+	playing bool
+	//finished is set when a play ended by itself, the supervisor waits for the next live stream to end
+	finished bool
+	stop     chan struct{}
+	mutex    sync.Mutex
+}
+
+// rerunLevel is a single quality level of a recorded session.
+type rerunLevel struct {
+	dir       string
+	transcode *Transcode
+	segments  map[string]bool
+}
+
+// rerunSegment is a playlist entry of the source quality level.
+type rerunSegment struct {
+	file     string
+	duration time.Duration
+}
+
+func NewReruns() *Reruns {
+	return &Reruns{}
+}
+
+// IsPlaying reports whether a rerun is currently being broadcasted.
+func (r *Reruns) IsPlaying() bool {
+	if r == nil {
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.playing
+}
+
+// StartSupervisor plays the configured reruns whenever the channel is not live, and stops them once it is. A play
+// that ended, because it did not loop or nothing was playable, is not started again until a live stream ended.
+func (r *Reruns) StartSupervisor() {
+	go func() {
+		for {
+			if isLive() {
+				r.Stop()
+				r.mutex.Lock()
+				r.finished = false
+				r.mutex.Unlock()
+			} else if config := currentConfig(); len(config.Reruns) > 0 && !r.IsPlaying() && !r.isFinished() {
+				r.Play(config.Reruns, config.RerunLoop)
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+func (r *Reruns) isFinished() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.finished
+}
+
+// Play starts broadcasting the sessions in order, looping them if requested.
+func (r *Reruns) Play(sessions []string, loop bool) {
+	r.mutex.Lock()
+	if r.playing {
+		r.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	r.playing = true
+	r.stop = stop
+	r.mutex.Unlock()
+
+	go func() {
+		finished := false
+		defer func() {
+			r.mutex.Lock()
+			if r.stop == stop {
+				r.playing = false
+				r.finished = finished
+			}
+			r.mutex.Unlock()
+		}()
+
+		for {
+			played := false
+			for _, session := range sessions {
+				err := r.playSession(resolveSessionPath(session), stop)
+				if errors.Is(err, errRerunStopped) {
+					return
+				}
+				if err != nil {
+					log.Println("error playing rerun", session, err.Error())
+					continue
+				}
+				played = true
+			}
+
+			//Nothing playable, do not spin on broken sessions.
+			if !loop || !played {
+				log.Println("Reruns ended, they play again after the next live stream")
+				finished = true
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the current rerun.
+func (r *Reruns) Stop() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.playing {
+		return
+	}
+
+	close(r.stop)
+	r.playing = false
+	log.Println("Rerun stopped")
+}
+
+var errRerunStopped = errors.New("rerun stopped")
+
+func (r *Reruns) playSession(session string, stop chan struct{}) error {
+	playlist, err := readRecordingPlaylist(filepath.Join(session, qualityLevelName(nil), RECORDING_PLAYLIST))
+	if err != nil {
+		return err
+	}
+	if len(playlist) == 0 {
+		return errors.New("recording has no segments")
+	}
+
+	levels, err := readRecordingLevels(session)
+	if err != nil {
+		return err
+	}
+
+	firstSegment, err := os.ReadFile(filepath.Join(session, qualityLevelName(nil), playlist[0].file))
+	if err != nil {
+		return err
+	}
+
+	info, err := probeVideoInfo(firstSegment)
+	if err != nil {
+		return err
+	}
+
+	source := &SourceInfo{Transcoders: make([]Transcode, 0, len(levels))}
+	source.Codec, source.Resolution, source.Framerate, err = parseSourceInfo(info)
+	if err != nil {
+		return err
+	}
+	for _, level := range levels {
+		source.Transcoders = append(source.Transcoders, *level.transcode)
+	}
+	streamSource.Store(source)
+
+	log.Println("Rerun playing:", session, "segments:", len(playlist), "quality levels:", len(levels)+1)
+
+	nextSegment := time.Now()
+	for _, entry := range playlist {
+		select {
+		case <-stop:
+			return errRerunStopped
+		case <-time.After(time.Until(nextSegment)):
+		}
+		nextSegment = nextSegment.Add(entry.duration)
+
+		segment, err := os.ReadFile(filepath.Join(session, qualityLevelName(nil), entry.file))
+		if err != nil {
+			log.Println("error reading rerun segment", err.Error())
+			continue
+		}
+
+		id := int(segmentId.Load())
+		transcodedChunksArray := [][][]byte{ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, id)}
+		for _, level := range levels {
+			//A level that is missing a segment falls back on the previous (higher) quality.
+			if level.segments[entry.file] {
+				if levelSegment, err := os.ReadFile(filepath.Join(level.dir, entry.file)); err == nil {
+					segment = levelSegment
+				}
+			}
+			transcodedChunksArray = append(transcodedChunksArray, ChunkByByteSizeWithMetadata(segment, CHUNK_SIZE, id))
+		}
+
+		broadcastChunks(id, transcodedChunksArray)
+		segmentId.Add(1)
+
+		if id%10 == 0 {
+			go screengrabSegment(segment)
+		}
+	}
+
+	return nil
+}
+
+// readRecordingLevels returns the transcoded quality levels of a session, highest quality first.
+func readRecordingLevels(session string) ([]*rerunLevel, error) {
+	entries, err := os.ReadDir(session)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make([]*rerunLevel, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == qualityLevelName(nil) {
+			continue
+		}
+
+		transcodeStr := strings.Split(entry.Name(), "p")
+		if len(transcodeStr) != 2 {
+			continue
+		}
+		resolution, err := strconv.Atoi(transcodeStr[0])
+		if err != nil {
+			continue
+		}
+		framerate, err := strconv.Atoi(transcodeStr[1])
+		if err != nil {
+			continue
+		}
+
+		dir := filepath.Join(session, entry.Name())
+		playlist, err := readRecordingPlaylist(filepath.Join(dir, RECORDING_PLAYLIST))
+		if err != nil {
+			continue
+		}
+
+		segments := make(map[string]bool, len(playlist))
+		for _, entry := range playlist {
+			segments[entry.file] = true
+		}
+
+		levels = append(levels, &rerunLevel{
+			dir:       dir,
+			transcode: &Transcode{Resolution: resolution, Framerate: framerate},
+			segments:  segments,
+		})
+	}
+
+	sort.SliceStable(levels, func(i, j int) bool {
+		if levels[i].transcode.Resolution != levels[j].transcode.Resolution {
+			return levels[i].transcode.Resolution > levels[j].transcode.Resolution
+		}
+		return levels[i].transcode.Framerate > levels[j].transcode.Framerate
+	})
+
+	return levels, nil
+}
+
+// readRecordingPlaylist parses the segment entries of a playlist written by the Recorder.
+func readRecordingPlaylist(path string) ([]rerunSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	segments := make([]rerunSegment, 0)
+	var duration time.Duration
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if extinf, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			seconds, err := strconv.ParseFloat(strings.TrimSuffix(extinf, ","), 64)
+			if err != nil {
+				return nil, err
+			}
+			duration = time.Duration(seconds * float64(time.Second))
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		segments = append(segments, rerunSegment{file: line, duration: duration})
+	}
+
+	return segments, scanner.Err()
+}
+
+// resolveSessionPath resolves a session name relative to the recording path.
+func resolveSessionPath(session string) string {
+	if filepath.IsAbs(session) {
+		return session
+	}
+	if _, err := os.Stat(session); err == nil {
+		return session
+	}
+
+	recordPath := currentConfig().RecordPath
+	if recordPath == "" {
+		recordPath = "./recordings"
+	}
+	return filepath.Join(recordPath, session)
+}
+
+// streamMode reports whether viewers are watching live, a rerun, or nothing.
+func streamMode() string {
+	if isLive() {
+		return MODE_LIVE
+	}
+	if reruns.IsPlaying() {
+		return MODE_RERUN
+	}
+	return MODE_OFFLINE
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRerunsWithoutPlayableSessionsFinish(t *testing.T) {
+	r := NewReruns()
+	r.Play([]string{filepath.Join(t.TempDir(), "missing")}, true)
+
+	waitFor(t, func() bool { return !r.IsPlaying() })
+	if !r.isFinished() {
+		t.Error("expected a looping rerun without playable sessions to finish instead of retrying")
+	}
+}
+
+func TestRerunOfRecordingWithoutVideoFails(t *testing.T) {
+	resetHost()
+	recording := &Recorder{path: t.TempDir()}
+	recording.StartSession()
+	recording.WriteSegment(qualityLevelName(nil), 0, fakeAudioSegment, time.Now())
+	recording.EndSession()
+
+	err := NewReruns().playSession(onlySession(t, recording.path), make(chan struct{}))
+	if err == nil || !strings.Contains(err.Error(), "no video stream") {
+		t.Errorf("expected a recording without video to be refused, got %v", err)
+	}
+	if currentSource().Codec != "" {
+		t.Error("expected the source to stay unset")
+	}
+}
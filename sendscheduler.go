@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gonovon/transport"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+const SEND_QUEUE_SIZE = 256
+const SEND_WORKERS = 2
+
+var sender *SendScheduler
+
+// SendScheduler queues every outgoing payload on a bounded queue per sub-client, picked by the transport and
+// drained by a fixed pool of workers. Chunks of a segment are dropped once a newer segment of the same quality
+// level is queued.
+type SendScheduler struct {
+	client transport.Transport
+	queues []chan *sendJob
+
+	// The generation of a quality level increases with every new segment, queued chunks of older generations are stale.
+	generations map[int]*qualityGeneration
+	mutex       sync.Mutex
+
+	queued       atomic.Int64
+	sent         atomic.Int64
+	errors       atomic.Int64
+	droppedFull  atomic.Int64
+	droppedStale atomic.Int64
+	sendTime     atomic.Int64
+}
+
+type qualityGeneration struct {
+	segmentId  int
+	generation atomic.Int64
+}
+
+type sendJob struct {
+	dests   *nkngomobile.StringArray
+	payload *payloads.Payload
+	config  *nkn.MessageConfig
+
+	// Segment chunks carry their quality generation, other payloads are never stale.
+	quality    *qualityGeneration
+	generation int64
+}
+
+// SendStats is a snapshot of the scheduler counters.
+type SendStats struct {
+	Queued       int64
+	Sent         int64
+	Errors       int64
+	DroppedFull  int64
+	DroppedStale int64
+	QueueLength  int
+	AvgSendTime  time.Duration
+}
+
+func NewSendScheduler(client transport.Transport) *SendScheduler {
+	s := &SendScheduler{
+		client:      client,
+		queues:      make([]chan *sendJob, client.SubClients()),
+		generations: make(map[int]*qualityGeneration),
+	}
+	for i := range s.queues {
+		s.queues[i] = make(chan *sendJob, SEND_QUEUE_SIZE)
+	}
+	return s
+}
+
+// Start starts the workers of every sub-client queue.
+func (s *SendScheduler) Start() {
+	for i := range s.queues {
+		for w := 0; w < SEND_WORKERS; w++ {
+			go s.work(i)
+		}
+	}
+}
+
+// Send queues a payload that is sent regardless of newer segments, like replies and chat.
+func (s *SendScheduler) Send(dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) {
+	s.enqueue(&sendJob{dests: dests, payload: payload, config: config})
+}
+
+// SendChunk queues a segment chunk of a quality level, the segment id is read from the chunk prefix.
+func (s *SendScheduler) SendChunk(quality int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) {
+	if len(payload.Data) < 4 {
+		s.Send(dests, payload, config)
+		return
+	}
+	id := int(binary.LittleEndian.Uint32(payload.Data[:4]))
+
+	s.mutex.Lock()
+	q, ok := s.generations[quality]
+	if !ok {
+		q = &qualityGeneration{segmentId: id}
+		s.generations[quality] = q
+	}
+	if q.segmentId != id {
+		q.segmentId = id
+		q.generation.Add(1)
+	}
+	generation := q.generation.Load()
+	s.mutex.Unlock()
+
+	s.enqueue(&sendJob{dests: dests, payload: payload, config: config, quality: q, generation: generation})
+}
+
+// enqueue puts a job on the queue of the sub-client the transport picks, skipping full queues. The job is dropped
+// when no sub-client can send or every queue it tried is full.
+func (s *SendScheduler) enqueue(job *sendJob) {
+	for i := 0; i < len(s.queues); i++ {
+		subClient := s.client.NextSubClient()
+		if subClient < 0 {
+			s.errors.Add(1)
+			return
+		}
+
+		select {
+		case s.queues[subClient] <- job:
+			s.queued.Add(1)
+			return
+		default:
+		}
+	}
+	s.droppedFull.Add(1)
+}
+
+func (s *SendScheduler) work(subClient int) {
+	for job := range s.queues[subClient] {
+		if job.quality != nil && job.quality.generation.Load() != job.generation {
+			s.droppedStale.Add(1)
+			continue
+		}
+
+		start := time.Now()
+		err := s.client.SendPayloadVia(subClient, job.dests, job.payload, job.config)
+		s.sendTime.Add(int64(time.Since(start)))
+		if err != nil {
+			s.errors.Add(1)
+			continue
+		}
+		s.sent.Add(1)
+	}
+}
+
+// Drain waits until all queues are empty or the timeout passes, it returns whether the queues were drained.
+func (s *SendScheduler) Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for s.Stats().QueueLength > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Stats returns a snapshot of the scheduler counters.
+func (s *SendScheduler) Stats() SendStats {
+	stats := SendStats{
+		Queued:       s.queued.Load(),
+		Sent:         s.sent.Load(),
+		Errors:       s.errors.Load(),
+		DroppedFull:  s.droppedFull.Load(),
+		DroppedStale: s.droppedStale.Load(),
+	}
+	for _, queue := range s.queues {
+		stats.QueueLength += len(queue)
+	}
+	if attempts := stats.Sent + stats.Errors; attempts > 0 {
+		stats.AvgSendTime = time.Duration(s.sendTime.Load() / attempts)
+	}
+	return stats
+}
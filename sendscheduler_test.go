@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"gonovon/transport"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+// schedulerTransport hands out its sub-clients in turn and fails every send when failing is set. With
+// unavailable set no sub-client can send.
+type schedulerTransport struct {
+	*transport.Loopback
+	unavailable bool
+	failing     bool
+	next        int
+}
+
+func (t *schedulerTransport) NextSubClient() int {
+	if t.unavailable {
+		return -1
+	}
+	t.next++
+	return t.next % t.SubClients()
+}
+
+func (t *schedulerTransport) SendPayloadVia(subClient int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	if t.failing {
+		return errors.New("send failed")
+	}
+	return nil
+}
+
+func TestSendScheduler(t *testing.T) {
+	dests := nkn.NewStringArray("viewer")
+	message := &payloads.Payload{Data: []byte("chat")}
+	chunk := func(segmentId int) *payloads.Payload {
+		return &payloads.Payload{Data: ChunkByByteSizeWithMetadata([]byte("ts"), CHUNK_SIZE, segmentId)[0]}
+	}
+
+	tests := []struct {
+		name        string
+		unavailable bool
+		failing     bool
+		queue       func(s *SendScheduler)
+		expected    SendStats
+	}{
+		{"queue bound", false, false, func(s *SendScheduler) {
+			for i := 0; i < transport.LOOPBACK_SUB_CLIENTS*SEND_QUEUE_SIZE+5; i++ {
+				s.Send(dests, message, nil)
+			}
+		}, SendStats{Queued: transport.LOOPBACK_SUB_CLIENTS * SEND_QUEUE_SIZE, Sent: transport.LOOPBACK_SUB_CLIENTS * SEND_QUEUE_SIZE, DroppedFull: 5}},
+		{"stale segments", false, false, func(s *SendScheduler) {
+			for i := 0; i < 3; i++ {
+				s.SendChunk(0, dests, chunk(1), nil)
+			}
+			s.SendChunk(1, dests, chunk(1), nil)
+			s.Send(dests, message, nil)
+			for i := 0; i < 2; i++ {
+				s.SendChunk(0, dests, chunk(2), nil)
+			}
+		}, SendStats{Queued: 7, Sent: 4, DroppedStale: 3}},
+		{"no sub-client", true, false, func(s *SendScheduler) {
+			s.Send(dests, message, nil)
+			s.SendChunk(0, dests, chunk(1), nil)
+		}, SendStats{Errors: 2}},
+		{"send errors", false, true, func(s *SendScheduler) {
+			for i := 0; i < 3; i++ {
+				s.Send(dests, message, nil)
+			}
+		}, SendStats{Queued: 3, Errors: 3}},
+	}
+	for _, test := range tests {
+		client := &schedulerTransport{Loopback: mustTransport(t), unavailable: test.unavailable, failing: test.failing}
+		s := NewSendScheduler(client)
+
+		//Nothing is sent before the workers start, so every payload is still queued
+		test.queue(s)
+		if stats := s.Stats(); stats.QueueLength != int(test.expected.Queued) {
+			t.Errorf("%v: expected %v queued payloads, got %v", test.name, test.expected.Queued, stats.QueueLength)
+		}
+
+		s.Start()
+		waitFor(t, func() bool {
+			stats := s.Stats()
+			return stats.QueueLength == 0 && stats.Sent+stats.Errors+stats.DroppedStale == test.expected.Sent+test.expected.Errors+test.expected.DroppedStale
+		})
+
+		stats := s.Stats()
+		stats.QueueLength, stats.AvgSendTime = 0, 0
+		if stats != test.expected {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.expected, stats)
+		}
+	}
+}
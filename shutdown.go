@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gonovon/viewer"
+
+	"github.com/bluenviron/mediamtx/core"
+)
+
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+const SHUTDOWN_CLOSE_TIMEOUT = 2 * time.Second //Part of SHUTDOWN_TIMEOUT kept for closing the client
+
+// waitForShutdown blocks until SIGINT or SIGTERM is received or MediaMTX exits, then shuts the host down.
+func waitForShutdown(s *core.Core) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	coreDone := make(chan struct{})
+	if s != nil {
+		go func() {
+			s.Wait()
+			close(coreDone)
+		}()
+	}
+
+	select {
+	case sig := <-signals:
+		log.Println("Received", sig, "- shutting down")
+	case <-coreDone:
+		log.Println("MediaMTX exited - shutting down")
+	}
+
+	shutdown(s)
+}
+
+// shutdown tells the viewers the stream ended, leaves the novon topic, flushes the chat log, donation ledger and
+// recording, stops ffmpeg and closes the client. Whatever is not done by SHUTDOWN_TIMEOUT is abandoned.
+func shutdown(s *core.Core) {
+	deadline := time.Now().Add(SHUTDOWN_TIMEOUT)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		reruns.Stop()
+		relay.Close()
+		pullSource.Stop()
+
+		if viewers.Count() > 0 {
+			publishText(viewer.STREAM_ENDED)
+		}
+
+		unsubscribed := make(chan struct{})
+		go func() {
+			if err := client.Unsubscribe("novon"); err != nil {
+				log.Println("error unsubscribing", err.Error())
+			}
+			close(unsubscribed)
+		}()
+
+		if err := chatLog.Close(); err != nil {
+			log.Println("error flushing chat log", err.Error())
+		}
+		if err := donationLedger.Close(); err != nil {
+			log.Println("error flushing donation ledger", err.Error())
+		}
+		recorder.EndSession()
+
+		if runner, ok := commands.(*execRunner); ok {
+			runner.StopAll()
+		}
+		if s != nil {
+			s.Close()
+		}
+
+		//Queued messages get what is left of the timeout, the client is closed either way
+		if !sender.Drain(time.Until(deadline) - SHUTDOWN_CLOSE_TIMEOUT) {
+			log.Println("Shutdown: dropping", sender.Stats().QueueLength, "queued messages")
+		}
+		select {
+		case <-unsubscribed:
+		case <-time.After(time.Until(deadline) - SHUTDOWN_CLOSE_TIMEOUT):
+		}
+
+		if err := client.Close(); err != nil {
+			log.Println("error closing client", err.Error())
+		}
+	}()
+
+	select {
+	case <-done:
+		log.Println("Shutdown complete")
+	case <-time.After(time.Until(deadline)):
+		log.Println("Shutdown timed out after", SHUTDOWN_TIMEOUT)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const MEDIAMTX_CONFIG = "mediamtx.yml"
+const STREAM_KEY_USER = "publisher"
+const STREAM_KEY_MARKER = "# gonovon stream key"
+const STREAM_KEY_CHECK_INTERVAL = 5 * time.Second
+
+var streamKeyLine = regexp.MustCompile(`(?m)^(\s*pass:)[^\n#]*(` + STREAM_KEY_MARKER + `)$`)
+
+// openPublishing is set when mediamtx.yml lets anyone publish, publishTSPart refuses the segments.
+var openPublishing atomic.Bool
+
+// streamKeyMutex protects streamKeyFile and lastStreamKeyCheck, segments are checked on MediaMTX goroutines.
+var streamKeyMutex sync.Mutex
+var streamKeyFile string
+var lastStreamKeyCheck time.Time
+
+// anyUserPermissions are the permissions of the anonymous user in the generated mediamtx.yml, followed by the
+// publisher user that needs the stream key.
+const anyUserPermissions = `  permissions:
+    # Available actions are: publish, read, playback, api, metrics, pprof.
+  - action: read
+    # Paths can be set to further restrict access to a specific path.
+    # An empty path means any path.
+    # Regular expressions can be used by using a tilde as prefix.
+    path:
+  - action: playback
+    path:
+
+  # Publisher, its password is the stream key gonovon printed on first run.
+  # Publish with the stream key "<path>?user=` + STREAM_KEY_USER + `&pass=<stream key>",
+  # run "gonovon streamkey rotate" to replace it.
+- user: ` + STREAM_KEY_USER + `
+  pass: ` + STREAM_KEY_MARKER + `
+  ips: []
+  permissions:
+  - action: publish
+    path:
+`
+
+// legacyAnyUserPermissions are the permissions of the anonymous user in mediamtx.yml files generated before stream
+// keys, anyone could publish.
+const legacyAnyUserPermissions = `  permissions:
+    # Available actions are: publish, read, playback, api, metrics, pprof.
+  - action: publish
+    # Paths can be set to further restrict access to a specific path.
+    # An empty path means any path.
+    # Regular expressions can be used by using a tilde as prefix.
+    path:
+  - action: read
+    path:
+  - action: playback
+    path:
+`
+
+// newStreamKey returns a random stream key and the MediaMTX credential of it, only the hash is stored.
+func newStreamKey() (string, string) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	key := hex.EncodeToString(b)
+
+	hash := sha256.Sum256([]byte(key))
+	return key, "sha256:" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// hasStreamKey reports whether a mediamtx.yml requires the stream key to publish.
+func hasStreamKey(yml []byte) bool {
+	match := streamKeyLine.FindSubmatch(yml)
+	return match != nil && strings.Contains(string(match[0]), "sha256:")
+}
+
+// setStreamKey sets the credential of the publisher user, mediamtx.yml files that predate stream keys are upgraded
+// when their permissions are still the generated ones.
+func setStreamKey(yml []byte, credential string) ([]byte, error) {
+	if !streamKeyLine.Match(yml) {
+		if !bytes.Contains(yml, []byte(legacyAnyUserPermissions)) {
+			return nil, errors.New("no publisher user found, add one with the stream key as its pass, like the generated mediamtx.yml")
+		}
+		yml = bytes.Replace(yml, []byte(legacyAnyUserPermissions), []byte(anyUserPermissions), 1)
+	}
+	return streamKeyLine.ReplaceAll(yml, []byte("${1} "+credential+" ${2}")), nil
+}
+
+// rotateStreamKey replaces the stream key in a mediamtx.yml, MediaMTX reloads the file by itself.
+func rotateStreamKey(ymlFile string) (string, error) {
+	yml, err := os.ReadFile(ymlFile)
+	if err != nil {
+		return "", err
+	}
+
+	key, credential := newStreamKey()
+	yml, err = setStreamKey(yml, credential)
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", ymlFile, err)
+	}
+
+	tmp := ymlFile + ".tmp"
+	if err := os.WriteFile(tmp, yml, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, ymlFile); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return key, nil
+}
+
+// printStreamKey prints a stream key with how to use it, the key is not stored and can't be shown again.
+func printStreamKey(key string) {
+	fmt.Println("")
+	fmt.Println("Your stream key, it is only shown once:")
+	fmt.Println("")
+	fmt.Printf("    live?user=%v&pass=%v\n", STREAM_KEY_USER, key)
+	fmt.Println("")
+	fmt.Println("Use it as the stream key in OBS, \"live\" can be any path name. Run gonovon streamkey rotate for a new one.")
+	fmt.Println("")
+}
+
+// checkStreamKey refuses segments while mediamtx.yml lets anyone publish.
+func checkStreamKey(ymlFile string) {
+	streamKeyMutex.Lock()
+	streamKeyFile = ymlFile
+	lastStreamKeyCheck = time.Now()
+	streamKeyMutex.Unlock()
+
+	yml, err := os.ReadFile(ymlFile)
+	if err != nil {
+		log.Println("could not check the stream key of", ymlFile, err.Error())
+		return
+	}
+	if hasStreamKey(yml) {
+		if openPublishing.Swap(false) {
+			log.Println("Stream key set, accepting segments")
+		}
+		return
+	}
+
+	if !openPublishing.Swap(true) {
+		log.Println("Warning:", ymlFile, "lets anyone publish, segments are refused until a stream key is set with: gonovon streamkey rotate")
+	}
+}
+
+// mediaMTXConfigFile returns the MediaMTX config file, the first MediaMTX argument when it is given.
+func mediaMTXConfigFile() string {
+	if hostOptions != nil && len(hostOptions.MediaMTXArgs) > 0 && !strings.HasPrefix(hostOptions.MediaMTXArgs[0], "-") {
+		return hostOptions.MediaMTXArgs[0]
+	}
+	return MEDIAMTX_CONFIG
+}
+
+// refuseOpenPublishing reports whether a segment has to be dropped because anyone can publish, a stream key set
+// while running is picked up within STREAM_KEY_CHECK_INTERVAL.
+func refuseOpenPublishing() bool {
+	if !openPublishing.Load() {
+		return false
+	}
+
+	//Only the first segment after the interval reads the file, the others use the last result
+	streamKeyMutex.Lock()
+	due := time.Since(lastStreamKeyCheck) > STREAM_KEY_CHECK_INTERVAL
+	if due {
+		lastStreamKeyCheck = time.Now()
+	}
+	ymlFile := streamKeyFile
+	streamKeyMutex.Unlock()
+
+	if due {
+		checkStreamKey(ymlFile)
+	}
+	return openPublishing.Load()
+}
+
+// runStreamKey manages the stream key: gonovon streamkey rotate [mediamtx.yml]
+func runStreamKey(args []string) {
+	if len(args) == 0 || args[0] != "rotate" {
+		fmt.Fprintln(os.Stderr, "usage: gonovon streamkey rotate [mediamtx.yml]")
+		os.Exit(2)
+	}
+
+	ymlFile := mediaMTXConfigFile()
+	if len(args) > 1 {
+		ymlFile = args[1]
+	}
+
+	key, err := rotateStreamKey(ymlFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	printStreamKey(key)
+	fmt.Println("The previous stream key no longer works, a running gonovon picks up the new one by itself.")
+}
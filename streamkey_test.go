@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamKeyRotation(t *testing.T) {
+	ymlFile := filepath.Join(t.TempDir(), "mediamtx.yml")
+	legacy := strings.Replace(mediaMTXDefaults, anyUserPermissions, legacyAnyUserPermissions, 1)
+	os.WriteFile(ymlFile, []byte(legacy), 0644)
+
+	if hasStreamKey([]byte(legacy)) || hasStreamKey([]byte(mediaMTXDefaults)) {
+		t.Fatal("expected no stream key before one is set")
+	}
+
+	key, err := rotateStreamKey(ymlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yml, _ := os.ReadFile(ymlFile)
+	if !hasStreamKey(yml) {
+		t.Fatal("expected the legacy mediamtx.yml to require a stream key")
+	}
+	if strings.Contains(string(yml), key) {
+		t.Error("expected only the hash of the stream key to be stored")
+	}
+	hash := sha256.Sum256([]byte(key))
+	if !strings.Contains(string(yml), "pass: sha256:"+base64.StdEncoding.EncodeToString(hash[:])+" "+STREAM_KEY_MARKER) {
+		t.Error("expected the publisher pass to be the hash of the stream key")
+	}
+	if strings.Contains(string(yml), legacyAnyUserPermissions) {
+		t.Error("expected the anonymous user to lose the publish permission")
+	}
+
+	rotated, err := rotateStreamKey(ymlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == key {
+		t.Error("expected a new stream key")
+	}
+	rotatedYml, _ := os.ReadFile(ymlFile)
+	if len(rotatedYml) != len(yml) || strings.Count(string(rotatedYml), STREAM_KEY_MARKER) != 1 {
+		t.Error("expected rotating to only replace the stream key")
+	}
+
+	custom := filepath.Join(t.TempDir(), "custom.yml")
+	os.WriteFile(custom, []byte("authInternalUsers:\n- user: any\n  permissions:\n  - action: publish\n"), 0644)
+	if _, err := rotateStreamKey(custom); err == nil {
+		t.Error("expected a customized mediamtx.yml without a publisher user to be refused")
+	}
+
+	checkStreamKey(custom)
+	if !refuseOpenPublishing() {
+		t.Error("expected segments to be refused while anyone can publish")
+	}
+
+	//Segments of several MediaMTX goroutines check at the same time
+	streamKeyMutex.Lock()
+	lastStreamKeyCheck = time.Time{}
+	streamKeyMutex.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refuseOpenPublishing()
+		}()
+	}
+	wg.Wait()
+
+	checkStreamKey(ymlFile)
+	if refuseOpenPublishing() {
+		t.Error("expected segments to be accepted with a stream key")
+	}
+}
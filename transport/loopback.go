@@ -0,0 +1,297 @@
+package transport
+
+import (
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+const LOOPBACK_QUEUE_SIZE = 4096
+const LOOPBACK_SEEN_SIZE = 16384
+const LOOPBACK_SUB_CLIENTS = 4
+
+var ErrClosed = errors.New("transport is closed")
+
+var subClientIdentifierRe = regexp.MustCompile(`^__\d+__$`)
+
+// LoopbackNetwork connects loopback transports in process, so a host and its viewers can run without NKN.
+type LoopbackNetwork struct {
+	transports    map[string]*Loopback
+	subscriptions map[string]map[string]string
+	mutex         sync.RWMutex
+}
+
+// Loopback is a Transport on a LoopbackNetwork, messages that do not fit its queue are dropped like on a congested network.
+type Loopback struct {
+	network   *LoopbackNetwork
+	account   *nkn.Account
+	onMessage *nkn.OnMessage
+
+	replies  map[string]*nkn.OnMessage
+	seen     map[string]struct{}
+	seenList []string
+	closed   bool
+	mutex    sync.Mutex
+
+	dropped atomic.Int64
+	next    atomic.Uint64
+}
+
+func NewLoopbackNetwork() *LoopbackNetwork {
+	return &LoopbackNetwork{
+		transports:    make(map[string]*Loopback),
+		subscriptions: make(map[string]map[string]string),
+	}
+}
+
+// NewTransport joins the network with a new random account.
+func (n *LoopbackNetwork) NewTransport() (*Loopback, error) {
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Loopback{
+		network:   n,
+		account:   account,
+		onMessage: nkn.NewOnMessage(LOOPBACK_QUEUE_SIZE, nil),
+		replies:   make(map[string]*nkn.OnMessage),
+		seen:      make(map[string]struct{}),
+	}
+
+	n.mutex.Lock()
+	n.transports[t.Address()] = t
+	n.mutex.Unlock()
+
+	return t, nil
+}
+
+// Subscribers returns the subscribers of a topic with their metadata.
+func (n *LoopbackNetwork) Subscribers(topic string) map[string]string {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	subscribers := make(map[string]string, len(n.subscriptions[topic]))
+	for address, meta := range n.subscriptions[topic] {
+		subscribers[address] = meta
+	}
+	return subscribers
+}
+
+func (n *LoopbackNetwork) transport(address string) *Loopback {
+	// Sub-client identifiers all reach the same transport, like a multiclient.
+	if s := strings.SplitN(address, ".", 2); len(s) == 2 && subClientIdentifierRe.MatchString(s[0]) {
+		address = s[1]
+	}
+
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.transports[address]
+}
+
+func (t *Loopback) SendPayload(dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	if t.isClosed() {
+		return ErrClosed
+	}
+
+	for _, dest := range dests.Elems() {
+		if target := t.network.transport(dest); target != nil {
+			target.receive(t.Address(), payload)
+		}
+	}
+	return nil
+}
+
+// SendPayloadVia sends like SendPayload, all sub-clients of a loopback transport share one connection.
+func (t *Loopback) SendPayloadVia(subClient int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	return t.SendPayload(dests, payload, config)
+}
+
+func (t *Loopback) SubClients() int {
+	return LOOPBACK_SUB_CLIENTS
+}
+
+func (t *Loopback) NextSubClient() int {
+	return int(t.next.Add(1) % LOOPBACK_SUB_CLIENTS)
+}
+
+func (t *Loopback) Send(dests *nkngomobile.StringArray, data interface{}, config *nkn.MessageConfig) (*nkn.OnMessage, error) {
+	noReply := config != nil && config.NoReply
+
+	payload, err := newPayload(data, noReply)
+	if err != nil {
+		return nil, err
+	}
+
+	onReply := nkn.NewOnMessage(1, nil)
+	if !noReply {
+		t.mutex.Lock()
+		t.replies[string(payload.MessageId)] = onReply
+		t.mutex.Unlock()
+	}
+
+	return onReply, t.SendPayload(dests, payload, config)
+}
+
+func (t *Loopback) Reply(msg *nkn.Message, data interface{}) error {
+	payload, err := nkn.NewReplyPayload(data, msg.MessageID)
+	if err != nil {
+		return err
+	}
+	return t.SendPayload(nkn.NewStringArray(msg.Src), payload, nil)
+}
+
+func (t *Loopback) Subscribe(topic string, duration int, meta string) error {
+	t.network.mutex.Lock()
+	defer t.network.mutex.Unlock()
+
+	if t.network.subscriptions[topic] == nil {
+		t.network.subscriptions[topic] = make(map[string]string)
+	}
+	t.network.subscriptions[topic][t.Address()] = meta
+	return nil
+}
+
+func (t *Loopback) Unsubscribe(topic string) error {
+	t.network.mutex.Lock()
+	defer t.network.mutex.Unlock()
+
+	delete(t.network.subscriptions[topic], t.Address())
+	return nil
+}
+
+func (t *Loopback) Subscribers(topic string) (map[string]string, error) {
+	return t.network.Subscribers(topic), nil
+}
+
+func (t *Loopback) OnMessage() *nkn.OnMessage {
+	return t.onMessage
+}
+
+func (t *Loopback) Address() string {
+	return hex.EncodeToString(t.account.PubKey())
+}
+
+func (t *Loopback) WalletAddress() string {
+	return t.account.WalletAddress()
+}
+
+// Dropped returns the number of messages dropped because the receive queue was full.
+func (t *Loopback) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+func (t *Loopback) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	t.network.mutex.Lock()
+	delete(t.network.transports, t.Address())
+	t.network.mutex.Unlock()
+
+	close(t.onMessage.C)
+	return nil
+}
+
+func (t *Loopback) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.closed
+}
+
+// receive delivers a payload as a message or as a reply, duplicates over multiple sub-clients are delivered once.
+func (t *Loopback) receive(src string, payload *payloads.Payload) {
+	data := payload.Data
+	switch payload.Type {
+	case payloads.PayloadType_TEXT:
+		textData := &payloads.TextData{}
+		if err := proto.Unmarshal(payload.Data, textData); err != nil {
+			return
+		}
+		data = []byte(textData.Text)
+	case payloads.PayloadType_ACK:
+		data = nil
+	}
+
+	msg := &nkn.Message{
+		Src:       src,
+		Data:      data,
+		Type:      int32(payload.Type),
+		MessageID: payload.MessageId,
+		NoReply:   payload.NoReply,
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	if len(payload.ReplyToId) > 0 {
+		onReply, ok := t.replies[string(payload.ReplyToId)]
+		if ok {
+			delete(t.replies, string(payload.ReplyToId))
+			onReply.C <- msg
+		}
+		return
+	}
+
+	key := string(payload.MessageId)
+	if _, ok := t.seen[key]; ok {
+		return
+	}
+	t.seen[key] = struct{}{}
+	t.seenList = append(t.seenList, key)
+	if len(t.seenList) > LOOPBACK_SEEN_SIZE {
+		delete(t.seen, t.seenList[0])
+		t.seenList = t.seenList[1:]
+	}
+
+	select {
+	case t.onMessage.C <- msg:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func newPayload(data interface{}, noReply bool) (*payloads.Payload, error) {
+	msgId, err := nkn.RandomBytes(nkn.MessageIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &payloads.Payload{
+		MessageId: msgId,
+		NoReply:   noReply,
+	}
+
+	switch v := data.(type) {
+	case []byte:
+		payload.Type = payloads.PayloadType_BINARY
+		payload.Data = v
+	case string:
+		payload.Type = payloads.PayloadType_TEXT
+		payload.Data, err = proto.Marshal(&payloads.TextData{Text: v})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nkn.ErrInvalidPayloadType
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+const SUB_CLIENT_MAX_ERRORS = 3
+const SUB_CLIENT_MIN_BACKOFF = time.Second
+const SUB_CLIENT_MAX_BACKOFF = 30 * time.Second
+
+// Sub-clients slower than SLOW_SUB_CLIENT_FACTOR times the average latency only get every SLOW_SUB_CLIENT_SHARE-th turn.
+const SLOW_SUB_CLIENT_FACTOR = 3
+const SLOW_SUB_CLIENT_SHARE = 4
+
+// SUBSCRIBERS_PAGE_SIZE is the most subscribers a node returns per request.
+const SUBSCRIBERS_PAGE_SIZE = 1000
+
+var ErrSubClientUnavailable = errors.New("sub-client is not connected")
+
+// NKN is a Transport over an nkn.MultiClient, payloads are spread round robin over its healthy sub-clients.
+type NKN struct {
+	client          *nkn.MultiClient
+	numSubClients   int
+	clientSendIndex int
+	health          []SubClientHealth
+	mutex           sync.Mutex
+
+	// connected reports whether a sub-client has a connection, tests replace it.
+	connected func(subClient int) bool
+}
+
+// SubClientHealth tracks the sends of one sub-client.
+type SubClientHealth struct {
+	Connected bool
+	Latency   time.Duration // moving average of successful sends
+	Sent      int64
+	Errors    int64
+
+	consecutiveErrors int
+	backoffUntil      time.Time
+	skipped           int
+}
+
+func NewNKN(client *nkn.MultiClient, numSubClients int) *NKN {
+	t := &NKN{
+		client:        client,
+		numSubClients: numSubClients,
+		health:        make([]SubClientHealth, numSubClients),
+	}
+	t.connected = t.isConnected
+	return t
+}
+
+// MultiClient returns the underlying multiclient, for wallet and chain operations.
+func (t *NKN) MultiClient() *nkn.MultiClient {
+	return t.client
+}
+
+// NextSubClient returns the next connected sub-client that is not backing off after errors. When every connected
+// sub-client is backing off the one whose backoff ends first is used, -1 is returned when none is connected.
+func (t *NKN) NextSubClient() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	average := t.averageLatency()
+	fallback := -1
+	backingOff := -1
+	for i := 0; i < t.numSubClients; i++ {
+		index := t.clientSendIndex % t.numSubClients
+		t.clientSendIndex++
+
+		h := &t.health[index]
+		h.Connected = t.connected(index)
+		if !h.Connected {
+			continue
+		}
+		if now.Before(h.backoffUntil) {
+			if backingOff < 0 || h.backoffUntil.Before(t.health[backingOff].backoffUntil) {
+				backingOff = index
+			}
+			continue
+		}
+		if fallback < 0 {
+			fallback = index
+		}
+
+		if average > 0 && h.Latency > SLOW_SUB_CLIENT_FACTOR*average {
+			h.skipped++
+			if h.skipped%SLOW_SUB_CLIENT_SHARE != 0 {
+				continue
+			}
+		}
+		return index
+	}
+
+	//Only slow sub-clients are left
+	if fallback >= 0 {
+		return fallback
+	}
+	return backingOff
+}
+
+func (t *NKN) isConnected(subClient int) bool {
+	client := t.client.GetClient(subClient)
+	return client != nil && !client.IsClosed() && client.GetConn() != nil
+}
+
+// averageLatency returns the average send latency of the sub-clients that have sent, the mutex must be held.
+func (t *NKN) averageLatency() time.Duration {
+	var total time.Duration
+	count := 0
+	for _, h := range t.health {
+		if h.Latency > 0 {
+			total += h.Latency
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / time.Duration(count)
+}
+
+// record updates the health of a sub-client after a send, repeated errors back it off exponentially.
+func (t *NKN) record(subClient int, latency time.Duration, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	h := &t.health[subClient]
+	if err != nil {
+		h.Errors++
+		h.consecutiveErrors++
+		if h.consecutiveErrors >= SUB_CLIENT_MAX_ERRORS {
+			backoff := SUB_CLIENT_MIN_BACKOFF << min(h.consecutiveErrors-SUB_CLIENT_MAX_ERRORS, 5)
+			h.backoffUntil = time.Now().Add(min(backoff, SUB_CLIENT_MAX_BACKOFF))
+		}
+		return
+	}
+
+	h.Sent++
+	h.consecutiveErrors = 0
+	if h.Latency == 0 {
+		h.Latency = latency
+	} else {
+		h.Latency = (4*h.Latency + latency) / 5
+	}
+}
+
+// Health returns a snapshot of the health of every sub-client.
+func (t *NKN) Health() []SubClientHealth {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	health := make([]SubClientHealth, len(t.health))
+	copy(health, t.health)
+	return health
+}
+
+func (t *NKN) SendPayload(dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	subClient := t.NextSubClient()
+	if subClient < 0 {
+		return ErrSubClientUnavailable
+	}
+	return t.SendPayloadVia(subClient, dests, payload, config)
+}
+
+func (t *NKN) SendPayloadVia(subClient int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error {
+	client := t.client.GetClient(subClient)
+	if client == nil {
+		t.record(subClient, 0, ErrSubClientUnavailable)
+		return ErrSubClientUnavailable
+	}
+
+	start := time.Now()
+	_, err := client.SendPayload(dests, payload, config)
+	t.record(subClient, time.Since(start), err)
+	return err
+}
+
+func (t *NKN) SubClients() int {
+	return t.numSubClients
+}
+
+func (t *NKN) Send(dests *nkngomobile.StringArray, data interface{}, config *nkn.MessageConfig) (*nkn.OnMessage, error) {
+	return t.client.Send(dests, data, config)
+}
+
+func (t *NKN) Reply(msg *nkn.Message, data interface{}) error {
+	return msg.Reply(data)
+}
+
+func (t *NKN) Subscribe(topic string, duration int, meta string) error {
+	_, err := t.client.Subscribe("", topic, duration, meta, nil)
+	return err
+}
+
+func (t *NKN) Unsubscribe(topic string) error {
+	_, err := t.client.Unsubscribe("", topic, nil)
+	return err
+}
+
+func (t *NKN) Subscribers(topic string) (map[string]string, error) {
+	subscribers := make(map[string]string)
+	for offset := 0; ; offset += SUBSCRIBERS_PAGE_SIZE {
+		page, err := t.client.GetSubscribers(topic, offset, SUBSCRIBERS_PAGE_SIZE, true, offset == 0, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for address, meta := range page.Subscribers.Map() {
+			subscribers[address] = meta
+		}
+		if offset == 0 && page.SubscribersInTxPool != nil {
+			for address, meta := range page.SubscribersInTxPool.Map() {
+				subscribers[address] = meta
+			}
+		}
+
+		if page.Subscribers.Len() < SUBSCRIBERS_PAGE_SIZE {
+			return subscribers, nil
+		}
+	}
+}
+
+func (t *NKN) OnMessage() *nkn.OnMessage {
+	return t.client.OnMessage
+}
+
+func (t *NKN) Address() string {
+	return t.client.Address()
+}
+
+func (t *NKN) WalletAddress() string {
+	return t.client.Account().WalletAddress()
+}
+
+func (t *NKN) Close() error {
+	return t.client.Close()
+}
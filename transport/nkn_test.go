@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errSend = errors.New("send failed")
+
+// newTestNKN returns an NKN transport without a multiclient, its sub-clients are connected unless listed.
+func newTestNKN(subClients int, disconnected ...int) *NKN {
+	t := NewNKN(nil, subClients)
+	t.connected = func(subClient int) bool {
+		for _, d := range disconnected {
+			if d == subClient {
+				return false
+			}
+		}
+		return true
+	}
+	return t
+}
+
+// picks returns how often every sub-client is picked in n turns.
+func picks(t *NKN, n int) map[int]int {
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		counts[t.NextSubClient()]++
+	}
+	return counts
+}
+
+func TestNextSubClientSkipsUnhealthySubClients(t *testing.T) {
+	tests := []struct {
+		name         string
+		disconnected []int
+		setup        func(n *NKN)
+		expected     map[int]int
+	}{
+		{"round robin", nil, func(n *NKN) {}, map[int]int{0: 4, 1: 4, 2: 4}},
+		{"disconnected", []int{1}, func(n *NKN) {}, map[int]int{0: 6, 2: 6}},
+		{"errors below the limit", nil, func(n *NKN) {
+			for i := 0; i < SUB_CLIENT_MAX_ERRORS-1; i++ {
+				n.record(1, 0, errSend)
+			}
+		}, map[int]int{0: 4, 1: 4, 2: 4}},
+		{"backing off", nil, func(n *NKN) {
+			for i := 0; i < SUB_CLIENT_MAX_ERRORS; i++ {
+				n.record(1, 0, errSend)
+			}
+		}, map[int]int{0: 6, 2: 6}},
+		{"success resets the errors", nil, func(n *NKN) {
+			n.record(1, 0, errSend)
+			n.record(1, 0, errSend)
+			n.record(1, time.Millisecond, nil)
+			n.record(1, 0, errSend)
+		}, map[int]int{0: 4, 1: 4, 2: 4}},
+	}
+	for _, test := range tests {
+		n := newTestNKN(3, test.disconnected...)
+		test.setup(n)
+		counts := picks(n, 12)
+		for subClient, expected := range test.expected {
+			if counts[subClient] != expected {
+				t.Errorf("%v: expected %v picked %v times, got %v", test.name, subClient, expected, counts)
+				break
+			}
+		}
+		if len(counts) != len(test.expected) {
+			t.Errorf("%v: unexpected picks %v", test.name, counts)
+		}
+	}
+}
+
+func TestSlowSubClientGetsEveryFourthTurn(t *testing.T) {
+	n := newTestNKN(4)
+	for subClient := 0; subClient < 3; subClient++ {
+		n.record(subClient, 10*time.Millisecond, nil)
+	}
+	n.record(3, time.Second, nil)
+
+	if counts := picks(n, 16); counts[3] != 1 || counts[0] != 5 {
+		t.Errorf("expected the slow sub-client to be picked once every %v turns, got %v", SLOW_SUB_CLIENT_SHARE, counts)
+	}
+}
+
+func TestSubClientBackoffExpires(t *testing.T) {
+	n := newTestNKN(2)
+	for i := 0; i < SUB_CLIENT_MAX_ERRORS; i++ {
+		n.record(1, 0, errSend)
+	}
+	if counts := picks(n, 4); counts[1] != 0 {
+		t.Fatalf("expected sub-client 1 to back off, got %v", counts)
+	}
+
+	//Every further error doubles the backoff
+	n.record(1, 0, errSend)
+	if backoff := time.Until(n.health[1].backoffUntil); backoff <= SUB_CLIENT_MIN_BACKOFF || backoff > 2*SUB_CLIENT_MIN_BACKOFF {
+		t.Errorf("expected the backoff to double, got %v", backoff)
+	}
+
+	time.Sleep(2*SUB_CLIENT_MIN_BACKOFF + 50*time.Millisecond)
+	if counts := picks(n, 4); counts[1] != 2 {
+		t.Errorf("expected sub-client 1 to be picked again after its backoff, got %v", counts)
+	}
+	if health := n.Health(); health[1].Errors != SUB_CLIENT_MAX_ERRORS+1 || !health[1].Connected {
+		t.Errorf("unexpected health %+v", health[1])
+	}
+}
+
+func TestNextSubClientFallsBackWhenAllAreUnhealthy(t *testing.T) {
+	n := newTestNKN(3, 2)
+	for _, subClient := range []int{0, 1} {
+		for i := 0; i < SUB_CLIENT_MAX_ERRORS; i++ {
+			n.record(subClient, 0, errSend)
+		}
+	}
+	//Sub-client 0 backs off the longest
+	n.record(0, 0, errSend)
+
+	if counts := picks(n, 3); counts[1] != 3 {
+		t.Errorf("expected the sub-client whose backoff ends first, got %v", counts)
+	}
+
+	if subClient := newTestNKN(2, 0, 1).NextSubClient(); subClient != -1 {
+		t.Errorf("expected -1 without connected sub-clients, got %v", subClient)
+	}
+}
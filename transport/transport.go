@@ -0,0 +1,43 @@
+// Package transport abstracts the network the host and its viewers exchange messages over, so the host can run
+// over NKN or over an in-process loopback network.
+package transport
+
+import (
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn-sdk-go/payloads"
+	"github.com/nknorg/nkngomobile"
+)
+
+// Transport sends and receives messages for a single address.
+type Transport interface {
+	// SendPayload sends a prepared payload to the destinations, payloads with a ReplyToId are replies.
+	SendPayload(dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error
+
+	// SendPayloadVia sends a prepared payload over one sub-client, indexes range from 0 to SubClients()-1.
+	SendPayloadVia(subClient int, dests *nkngomobile.StringArray, payload *payloads.Payload, config *nkn.MessageConfig) error
+
+	// SubClients returns the number of sub-clients payloads can be spread over.
+	SubClients() int
+
+	// NextSubClient picks the sub-client for the next payload, or returns -1 when none can send.
+	NextSubClient() int
+
+	// Send sends bytes or string data, the returned channel emits the reply unless config.NoReply is set.
+	Send(dests *nkngomobile.StringArray, data interface{}, config *nkn.MessageConfig) (*nkn.OnMessage, error)
+
+	// Reply sends bytes or string data as reply to a received message.
+	Reply(msg *nkn.Message, data interface{}) error
+
+	Subscribe(topic string, duration int, meta string) error
+	Unsubscribe(topic string) error
+
+	// Subscribers returns the subscribers of a topic with their metadata, including pending subscriptions.
+	Subscribers(topic string) (map[string]string, error)
+
+	// OnMessage emits every received message that is not a reply.
+	OnMessage() *nkn.OnMessage
+
+	Address() string
+	WalletAddress() string
+	Close() error
+}
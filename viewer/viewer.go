@@ -0,0 +1,492 @@
+// Package viewer implements the viewer side of the novon protocol: it joins a host, reassembles the chunked
+// MPEG-TS segments and emits them once complete.
+package viewer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gonovon/transport"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+// The host sends every chunk to these sub-client identifiers of a viewer.
+const VIEWER_SUB_CLIENTS = 3
+
+// The host removes viewers that did not ping within 30 seconds.
+const DEFAULT_PING_INTERVAL = 10 * time.Second
+const DEFAULT_SEGMENT_TIMEOUT = 10 * time.Second
+const DEFAULT_REQUEST_TIMEOUT = 10 * time.Second
+
+const CHUNK_PREFIX_SIZE = 3 * 4
+
+// MAX_TOTAL_CHUNKS bounds the chunk count of the prefix before it sizes an allocation. The largest segment MediaMTX
+// hands over is 50MB (hlsSegmentMaxSize), about 800 chunks of 64000 bytes.
+const MAX_TOTAL_CHUNKS = 1024
+
+// STREAM_ENDED is the text message a host broadcasts when it shuts down.
+const STREAM_ENDED = "stream-ended"
+
+var ErrClosed = errors.New("viewer is closed")
+var ErrTimeout = errors.New("request timed out")
+
+// Config holds the viewer options, zero values are replaced by defaults.
+type Config struct {
+	PingInterval   time.Duration
+	SegmentTimeout time.Duration
+	RequestTimeout time.Duration
+	Quality        int
+	// IdleTimeout closes the viewer when the host sent nothing for this long, 0 keeps it open.
+	IdleTimeout time.Duration
+}
+
+// Segment is a complete MPEG-TS segment rebuilt from its chunks.
+type Segment struct {
+	Id        int
+	Data      []byte
+	Chunks    [][]byte // the chunks as received, including their prefix
+	FirstSeen time.Time
+	Completed time.Time
+}
+
+// Transcode is a quality level offered by the host.
+type Transcode struct {
+	Resolution int
+	Framerate  int
+}
+
+// DvrWindow is the range of segment ids the host can still send.
+type DvrWindow struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+// ChannelInfo is the host reply to "channelinfo".
+type ChannelInfo struct {
+	Panels        string      `json:"panels"`
+	Viewers       int         `json:"viewers"`
+	Role          string      `json:"role"`
+	QualityLevels []Transcode `json:"qualityLevels"`
+	Mode          string      `json:"mode"`
+	Dvr           *DvrWindow  `json:"dvr,omitempty"`
+}
+
+// ChatMessage is a chat message published by the host.
+type ChatMessage struct {
+	Id   uint64 `json:"id,string"`
+	Text string `json:"text"`
+	Hash string `json:"hash"`
+	Src  string `json:"src"`
+	Role string `json:"role"`
+}
+
+// Stats counts the delivery of segments.
+type Stats struct {
+	Completed  int
+	Discarded  int
+	Duplicates int
+	Bytes      int
+	Dropped    int //Chat and text messages dropped because Chat or Text was full
+}
+
+// Viewer watches a single host over a transport. Chat and Text don't have to be read, messages that don't fit are
+// dropped so they never hold up Segments.
+type Viewer struct {
+	Segments chan *Segment
+	Chat     chan *ChatMessage
+	Text     chan string
+
+	client transport.Transport
+	host   string
+	config Config
+
+	quality   int
+	pending   map[int]*pendingSegment
+	completed map[int]time.Time
+	stats     Stats
+	lastHeard time.Time
+	mutex     sync.Mutex
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type pendingSegment struct {
+	chunks    [][]byte
+	received  int
+	firstSeen time.Time
+}
+
+// New creates a viewer of host, an NKN client needs at least VIEWER_SUB_CLIENTS sub-clients.
+func New(client transport.Transport, host string, config *Config) *Viewer {
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = DEFAULT_PING_INTERVAL
+	}
+	if c.SegmentTimeout <= 0 {
+		c.SegmentTimeout = DEFAULT_SEGMENT_TIMEOUT
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+
+	return &Viewer{
+		Segments:  make(chan *Segment, 32),
+		Chat:      make(chan *ChatMessage, 32),
+		Text:      make(chan string, 32),
+		client:    client,
+		host:      host,
+		config:    c,
+		quality:   c.Quality,
+		pending:   make(map[int]*pendingSegment),
+		completed: make(map[int]time.Time),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start joins the host with a "ping", selects the configured quality and starts receiving.
+func (v *Viewer) Start() error {
+	v.mutex.Lock()
+	v.lastHeard = time.Now()
+	v.mutex.Unlock()
+
+	go v.receive()
+
+	if err := v.send("ping"); err != nil {
+		return err
+	}
+
+	if _, err := v.SetQuality(v.quality); err != nil {
+		return err
+	}
+
+	go v.keepalive()
+	go v.cleanup()
+
+	return nil
+}
+
+// Close sends "disconnect" to the host and stops the viewer, the channels are closed.
+func (v *Viewer) Close() error {
+	var err error
+	v.closeOnce.Do(func() {
+		err = v.send("disconnect")
+		close(v.done)
+	})
+	return err
+}
+
+// Host returns the address of the watched host.
+func (v *Viewer) Host() string {
+	return v.host
+}
+
+// Quality returns the selected quality level, 0 is the source quality.
+func (v *Viewer) Quality() int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.quality
+}
+
+// SetQuality selects a quality level and returns the current segment id of the host.
+func (v *Viewer) SetQuality(quality int) (int, error) {
+	if quality < 0 || quality > 9 {
+		return 0, errors.New("quality level must be between 0 and 9")
+	}
+
+	reply, err := v.Request("quality" + strconv.Itoa(quality))
+	if err != nil {
+		return 0, err
+	}
+
+	v.mutex.Lock()
+	v.quality = quality
+	v.mutex.Unlock()
+
+	return strconv.Atoi(string(reply))
+}
+
+// ChannelInfo requests the channel info of the host.
+func (v *Viewer) ChannelInfo() (*ChannelInfo, error) {
+	reply, err := v.Request("channelinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ChannelInfo{}
+	if err := json.Unmarshal(reply, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// RequestSegment asks the host to resend a segment from its dvr window, it is emitted on Segments.
+func (v *Viewer) RequestSegment(id int) error {
+	reply, err := v.Request("segment " + strconv.Itoa(id) + " " + strconv.Itoa(v.Quality()))
+	if err != nil {
+		return err
+	}
+	if text, ok := strings.CutPrefix(string(reply), "error: "); ok {
+		return errors.New(text)
+	}
+
+	//Allow the resent segment through the duplicate filter
+	v.mutex.Lock()
+	delete(v.completed, id)
+	v.mutex.Unlock()
+	return nil
+}
+
+// SendChat sends a chat message to the host.
+func (v *Viewer) SendChat(text string) error {
+	content, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"type": "chat-message", "content": json.RawMessage(content)})
+	if err != nil {
+		return err
+	}
+
+	reply, err := v.Request(string(data))
+	if err != nil {
+		return err
+	}
+	if text, ok := strings.CutPrefix(string(reply), "error: "); ok {
+		return errors.New(text)
+	}
+	return nil
+}
+
+// Request sends data to the host and waits for its reply.
+func (v *Viewer) Request(data string) ([]byte, error) {
+	onReply, err := v.client.Send(nkn.NewStringArray(v.host), data, &nkn.MessageConfig{Unencrypted: true})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-onReply.C:
+		if reply == nil {
+			return nil, ErrClosed
+		}
+		return reply.Data, nil
+	case <-time.After(v.config.RequestTimeout):
+		return nil, ErrTimeout
+	case <-v.done:
+		return nil, ErrClosed
+	}
+}
+
+// Stats returns the delivery counters.
+func (v *Viewer) Stats() Stats {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.stats
+}
+
+func (v *Viewer) send(data string) error {
+	_, err := v.client.Send(nkn.NewStringArray(v.host), data, &nkn.MessageConfig{Unencrypted: true, NoReply: true})
+	return err
+}
+
+func (v *Viewer) keepalive() {
+	ticker := time.NewTicker(v.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.send("ping")
+		case <-v.done:
+			return
+		}
+	}
+}
+
+func (v *Viewer) receive() {
+	defer close(v.Segments)
+	defer close(v.Chat)
+	defer close(v.Text)
+
+	for {
+		select {
+		case msg := <-v.client.OnMessage().C:
+			if msg == nil {
+				return
+			}
+			if msg.Src != v.host {
+				continue
+			}
+			v.mutex.Lock()
+			v.lastHeard = time.Now()
+			v.mutex.Unlock()
+			v.handleMessage(msg)
+		case <-v.done:
+			return
+		}
+	}
+}
+
+func (v *Viewer) handleMessage(msg *nkn.Message) {
+	//Text payloads are control messages such as chat deletions.
+	if msg.Type == nkn.TextType {
+		v.emitText(string(msg.Data))
+		return
+	}
+
+	//Chat is published as binary json, chunks start with their little endian segment id.
+	if len(msg.Data) > 0 && msg.Data[0] == '{' && json.Valid(msg.Data) {
+		chatMsg := &ChatMessage{}
+		if err := json.Unmarshal(msg.Data, chatMsg); err == nil {
+			select {
+			case v.Chat <- chatMsg:
+			default:
+				v.dropMessage()
+			}
+		}
+		return
+	}
+
+	if segment := v.addChunk(msg.Data); segment != nil {
+		select {
+		case v.Segments <- segment:
+		case <-v.done:
+		}
+	}
+}
+
+func (v *Viewer) emitText(text string) {
+	select {
+	case v.Text <- text:
+	default:
+		v.dropMessage()
+	}
+}
+
+func (v *Viewer) dropMessage() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.stats.Dropped++
+}
+
+// addChunk stores a chunk and returns the segment once all of its chunks arrived.
+func (v *Viewer) addChunk(data []byte) *Segment {
+	id, chunkId, totalChunks, ok := ParseChunk(data)
+	if !ok {
+		return nil
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if _, done := v.completed[id]; done {
+		v.stats.Duplicates++
+		return nil
+	}
+
+	pending, ok := v.pending[id]
+	if !ok {
+		pending = &pendingSegment{
+			chunks:    make([][]byte, totalChunks),
+			firstSeen: time.Now(),
+		}
+		v.pending[id] = pending
+	}
+
+	if chunkId >= len(pending.chunks) {
+		return nil
+	}
+	if pending.chunks[chunkId] != nil {
+		v.stats.Duplicates++
+		return nil
+	}
+
+	pending.chunks[chunkId] = data
+	pending.received++
+	if pending.received < len(pending.chunks) {
+		return nil
+	}
+
+	size := 0
+	for _, chunk := range pending.chunks {
+		size += len(chunk) - CHUNK_PREFIX_SIZE
+	}
+	segmentData := make([]byte, 0, size)
+	for _, chunk := range pending.chunks {
+		segmentData = append(segmentData, chunk[CHUNK_PREFIX_SIZE:]...)
+	}
+
+	delete(v.pending, id)
+	v.completed[id] = time.Now()
+	v.stats.Completed++
+	v.stats.Bytes += size
+
+	return &Segment{
+		Id:        id,
+		Data:      segmentData,
+		Chunks:    pending.chunks,
+		FirstSeen: pending.firstSeen,
+		Completed: time.Now(),
+	}
+}
+
+// cleanup discards segments that did not complete in time.
+func (v *Viewer) cleanup() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.mutex.Lock()
+			for id, pending := range v.pending {
+				if time.Since(pending.firstSeen) > v.config.SegmentTimeout {
+					delete(v.pending, id)
+					v.stats.Discarded++
+				}
+			}
+			for id, completed := range v.completed {
+				if time.Since(completed) > 2*v.config.SegmentTimeout {
+					delete(v.completed, id)
+				}
+			}
+			idle := v.config.IdleTimeout > 0 && time.Since(v.lastHeard) > v.config.IdleTimeout
+			v.mutex.Unlock()
+
+			//The host is gone, closing the channels tells the user of the viewer
+			if idle {
+				v.Close()
+				return
+			}
+		case <-v.done:
+			return
+		}
+	}
+}
+
+// ParseChunk reads the 12 byte prefix of a chunk: segment id, chunk id and total chunks.
+func ParseChunk(data []byte) (segmentId int, chunkId int, totalChunks int, ok bool) {
+	if len(data) < CHUNK_PREFIX_SIZE {
+		return 0, 0, 0, false
+	}
+
+	segmentId = int(binary.LittleEndian.Uint32(data[:4]))
+	chunkId = int(binary.LittleEndian.Uint32(data[4:8]))
+	totalChunks = int(binary.LittleEndian.Uint32(data[8:12]))
+
+	if totalChunks == 0 || totalChunks > MAX_TOTAL_CHUNKS || chunkId >= totalChunks {
+		return 0, 0, 0, false
+	}
+	return segmentId, chunkId, totalChunks, true
+}
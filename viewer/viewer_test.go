@@ -0,0 +1,133 @@
+package viewer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"gonovon/transport"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+func chunk(segmentId, chunkId, totalChunks int, data []byte) []byte {
+	prefix := make([]byte, CHUNK_PREFIX_SIZE)
+	binary.LittleEndian.PutUint32(prefix[:4], uint32(segmentId))
+	binary.LittleEndian.PutUint32(prefix[4:8], uint32(chunkId))
+	binary.LittleEndian.PutUint32(prefix[8:], uint32(totalChunks))
+	return append(prefix, data...)
+}
+
+func TestAddChunkOutOfOrderAndDuplicates(t *testing.T) {
+	v := New(nil, "host", nil)
+
+	chunks := [][]byte{
+		chunk(4, 2, 3, []byte("cc")),
+		chunk(4, 0, 3, []byte("aa")),
+		chunk(4, 0, 3, []byte("aa")),
+	}
+	for _, c := range chunks {
+		if segment := v.addChunk(c); segment != nil {
+			t.Fatal("segment completed before all chunks arrived")
+		}
+	}
+
+	segment := v.addChunk(chunk(4, 1, 3, []byte("bb")))
+	if segment == nil {
+		t.Fatal("expected segment to complete")
+	}
+	if segment.Id != 4 || !bytes.Equal(segment.Data, []byte("aabbcc")) {
+		t.Errorf("unexpected segment %v: %q", segment.Id, segment.Data)
+	}
+	if len(segment.Chunks) != 3 || !bytes.Equal(segment.Chunks[1], chunk(4, 1, 3, []byte("bb"))) {
+		t.Error("expected the chunks as received, including their prefix")
+	}
+
+	// Late duplicates of a completed segment are ignored
+	if segment := v.addChunk(chunk(4, 1, 3, []byte("bb"))); segment != nil {
+		t.Error("completed segment emitted twice")
+	}
+
+	if stats := v.Stats(); stats.Completed != 1 || stats.Duplicates != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestParseChunkRejectsInvalidPrefix(t *testing.T) {
+	if _, _, _, ok := ParseChunk([]byte("short")); ok {
+		t.Error("expected chunk shorter than the prefix to be rejected")
+	}
+	if _, _, _, ok := ParseChunk(chunk(0, 3, 3, nil)); ok {
+		t.Error("expected chunk id beyond the total to be rejected")
+	}
+	if _, _, _, ok := ParseChunk(chunk(0, 0, MAX_TOTAL_CHUNKS+1, nil)); ok {
+		t.Error("expected a total above MAX_TOTAL_CHUNKS to be rejected")
+	}
+
+	v := New(nil, "host", nil)
+	if segment := v.addChunk(chunk(0, 0, 0xffffffff, nil)); segment != nil || len(v.pending) != 0 {
+		t.Error("expected a huge total to be dropped before allocating")
+	}
+}
+
+func TestIdleTimeoutClosesViewer(t *testing.T) {
+	network := transport.NewLoopbackNetwork()
+	host, err := network.NewTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := network.NewTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The host answers the quality request and then goes silent
+	go func() {
+		for msg := range host.OnMessage().C {
+			if string(msg.Data) == "quality0" {
+				host.Reply(msg, "0")
+			}
+		}
+	}()
+
+	v := New(client, host.Address(), &Config{IdleTimeout: 500 * time.Millisecond})
+	if err := v.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case _, ok := <-v.Segments:
+		if ok {
+			t.Fatal("expected no segments")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the viewer of a silent host to close")
+	}
+}
+
+func TestUnreadChatDoesNotBlockSegments(t *testing.T) {
+	v := New(nil, "host", nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 40; i++ {
+			v.handleMessage(&nkn.Message{Src: "host", Type: nkn.BinaryType, Data: []byte(`{"text":"hi"}`)})
+			v.handleMessage(&nkn.Message{Src: "host", Type: nkn.TextType, Data: []byte("deleted")})
+		}
+		v.handleMessage(&nkn.Message{Src: "host", Type: nkn.BinaryType, Data: chunk(1, 0, 1, []byte("ts"))})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected unread chat and text to be dropped instead of blocking")
+	}
+	if segment := <-v.Segments; segment.Id != 1 {
+		t.Errorf("expected segment 1, got %v", segment.Id)
+	}
+	if stats := v.Stats(); stats.Dropped != 2*(40-cap(v.Chat)) {
+		t.Errorf("expected the messages that did not fit to be counted, got %+v", stats)
+	}
+}
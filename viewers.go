@@ -10,15 +10,16 @@ import (
 	"github.com/nknorg/nkngomobile"
 )
 
-var viewerAddresses []string
-var viewerSubClientAddresses [VIEWER_SUB_CLIENTS]*nkngomobile.StringArray
-
 // Viewers is a thread-safe collection of message addresses with last receive timestamps.
 type Viewers struct {
 	messages      map[string]*messageData
 	viewerQuality map[string]int
 	mutex         sync.RWMutex
 	timeout       time.Duration
+
+	//addresses and subClientAddresses are rebuilt by SetAddresses whenever a viewer joins or leaves
+	addresses          []string
+	subClientAddresses [VIEWER_SUB_CLIENTS]*nkngomobile.StringArray
 }
 
 // messageData holds the last received time for an address.
@@ -54,27 +55,55 @@ func (ms *Viewers) AddOrUpdateAddress(address string) (isNew bool) {
 	return !ok
 }
 
-// GetAddresses returns an array of all addresses in the store.
+// SetQuality sets the quality level a viewer receives, 0 is the source quality.
+func (ms *Viewers) SetQuality(address string, quality int) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.viewerQuality[address] = quality
+}
+
+// SetAddresses rebuilds the address lists from the store, the caller holds the write lock.
 func (ms *Viewers) SetAddresses() {
 	//addresses strings
 	addresses := make([]string, 0, len(ms.messages))
 	for address := range ms.messages {
 		addresses = append(addresses, address)
 	}
-	viewerAddresses = addresses
+	ms.addresses = addresses
 
 	//create nkn string arrays for all viewer subclients
 	nknAddrStrings := [VIEWER_SUB_CLIENTS]*nkngomobile.StringArray{}
 	for i := 0; i < VIEWER_SUB_CLIENTS; i++ {
-		prefixedAddresses := make([]string, len(viewerAddresses))
-		for j, address := range viewerAddresses {
+		prefixedAddresses := make([]string, len(addresses))
+		for j, address := range addresses {
 			prefixedAddresses[j] = "__" + strconv.Itoa(i) + "__." + address
 		}
 
 		nknAddrStrings[i] = nkn.NewStringArray(prefixedAddresses...)
 	}
 
-	viewerSubClientAddresses = nknAddrStrings
+	ms.subClientAddresses = nknAddrStrings
+}
+
+// Count returns the number of viewers.
+func (ms *Viewers) Count() int {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return len(ms.addresses)
+}
+
+// Addresses returns the addresses of all viewers, the slice is not modified afterwards.
+func (ms *Viewers) Addresses() []string {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.addresses
+}
+
+// SubClientAddresses returns the viewer addresses prefixed for every viewer sub-client.
+func (ms *Viewers) SubClientAddresses() [VIEWER_SUB_CLIENTS]*nkngomobile.StringArray {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.subClientAddresses
 }
 
 // Cleanup removes addresses from the store that haven't received messages in the timeout duration.
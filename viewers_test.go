@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestViewersTimeout(t *testing.T) {
+	ms := NewViewers(50 * time.Millisecond)
+
+	if !ms.AddOrUpdateAddress("a") {
+		t.Fatal("expected a to be a new viewer")
+	}
+	if ms.AddOrUpdateAddress("a") {
+		t.Fatal("expected a to be a known viewer")
+	}
+	ms.AddOrUpdateAddress("b")
+	if ms.Count() != 2 {
+		t.Fatalf("expected 2 viewer addresses, got %v", ms.Count())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	ms.AddOrUpdateAddress("b")
+	time.Sleep(30 * time.Millisecond)
+	ms.Cleanup()
+
+	if ms.Count() != 1 || ms.Addresses()[0] != "b" {
+		t.Fatalf("expected only b to remain, got %v", ms.Addresses())
+	}
+	if addresses := ms.SubClientAddresses(); addresses[0].Len() != 1 || addresses[0].Get(0) != "__0__.b" {
+		t.Errorf("unexpected sub-client addresses: %v", addresses[0].Elems())
+	}
+
+	ms.Remove("b")
+	if ms.Count() != 0 {
+		t.Errorf("expected no viewers after disconnect, got %v", ms.Addresses())
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gonovon/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/common"
+	"github.com/nknorg/nkn/v2/transaction"
+	"golang.org/x/term"
+)
+
+// About an hour of blocks
+const WALLET_SCAN_BLOCKS = 180
+const WALLET_SCAN_CONCURRENCY = 8
+
+// IncomingTransfer is a transfer to the host wallet found on chain, with the donation it paid for if any.
+type IncomingTransfer struct {
+	Height   uint32          `json:"height"`
+	Time     time.Time       `json:"time"`
+	Hash     string          `json:"hash"`
+	Sender   string          `json:"sender"`
+	Amount   string          `json:"amount"`
+	Donation *DonationRecord `json:"donation,omitempty"`
+}
+
+// runWallet manages the host wallet: gonovon wallet address|balance|donations|transfer
+func runWallet(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: gonovon wallet address|balance [flags]")
+		fmt.Fprintln(os.Stderr, "       gonovon wallet donations [flags]")
+		fmt.Fprintln(os.Stderr, "       gonovon wallet transfer [flags] <wallet address> <amount|all>")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("wallet "+args[0], flag.ExitOnError)
+	configFile := flags.String("config", envOr("CONFIG", "./config.json"), "config file, or $GONOVON_CONFIG")
+	blocks := flags.Int("blocks", WALLET_SCAN_BLOCKS, "donations: number of recent blocks to scan")
+	asJSON := flags.Bool("json", false, "donations: print JSON instead of a table")
+	fee := flags.String("fee", "0", "transfer: transaction fee in NKN")
+	dryRun := flags.Bool("dry-run", false, "transfer: build and print the transaction without sending it")
+	yes := flags.Bool("yes", false, "transfer: do not ask for confirmation")
+	flags.Parse(args[1:])
+
+	wallet, cfg, err := openWallet(*configFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch args[0] {
+	case "address":
+		fmt.Println("Wallet address:", wallet.Address())
+		fmt.Println("Client address:", hex.EncodeToString(wallet.PubKey()))
+	case "balance":
+		balance, err := wallet.Balance()
+		if err != nil {
+			log.Fatalln("could not get balance:", err)
+		}
+		fmt.Println(wallet.Address(), balance.String(), "NKN")
+	case "donations":
+		records, err := readDonationLedger(donationLedgerPath(cfg))
+		if err != nil {
+			log.Fatalln("could not read donation ledger:", err)
+		}
+		transfers, err := incomingTransfers(wallet.Address(), *blocks)
+		if err != nil {
+			log.Fatalln("could not scan blocks:", err)
+		}
+		matchDonations(transfers, records)
+		if *asJSON {
+			encoder := stdjson.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(transfers)
+		} else {
+			printTransfers(transfers, *blocks)
+		}
+	case "transfer":
+		if flags.NArg() != 2 {
+			usage()
+		}
+		if err := transfer(wallet, flags.Arg(0), flags.Arg(1), *fee, *dryRun, *yes); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		usage()
+	}
+}
+
+// openWallet opens the host wallet with the seed of the config file, unlocking the keystore if needed.
+func openWallet(configFile string) (*nkn.Wallet, *Config, error) {
+	cfg, err := readConfig(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := unlockKeystore(configFile, cfg); err != nil {
+		return nil, nil, err
+	}
+
+	seed, err := hex.DecodeString(cfg.Seed)
+	if err != nil || len(seed) != 32 {
+		return nil, nil, fmt.Errorf("%v has no valid seed, run gonovon config check", configFile)
+	}
+	account, err := nkn.NewAccount(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	wallet, err := nkn.NewWallet(account, nil)
+	return wallet, cfg, err
+}
+
+// incomingTransfers scans the most recent blocks for transfers to the wallet address, newest first.
+func incomingTransfers(address string, blocks int) ([]*IncomingTransfer, error) {
+	height, err := nkn.GetHeight(nkn.GetDefaultRPCConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var scanErr error
+	transfers := make([]*IncomingTransfer, 0)
+	limit := make(chan struct{}, WALLET_SCAN_CONCURRENCY)
+	for h := int64(height); h > int64(height)-int64(blocks) && h >= 0; h-- {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(h int64) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			block := &json.Block{}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := nkn.RPCCall(ctx, "getblock", map[string]interface{}{"height": h}, block, nkn.GetDefaultRPCConfig())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				scanErr = fmt.Errorf("block %v: %w", h, err)
+				return
+			}
+			transfers = append(transfers, blockTransfers(block, address)...)
+		}(h)
+	}
+	wg.Wait()
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	sort.Slice(transfers, func(i, j int) bool {
+		if transfers[i].Height != transfers[j].Height {
+			return transfers[i].Height > transfers[j].Height
+		}
+		return transfers[i].Hash < transfers[j].Hash
+	})
+	return transfers, nil
+}
+
+// blockTransfers returns the transfers to the wallet address in a block.
+func blockTransfers(block *json.Block, address string) []*IncomingTransfer {
+	transfers := make([]*IncomingTransfer, 0)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if tx.TxType != "TRANSFER_ASSET_TYPE" {
+			continue
+		}
+		transfer, err := parseTransfer(tx)
+		if err != nil || transfer.Recipient != address {
+			continue
+		}
+		transfers = append(transfers, &IncomingTransfer{
+			Height: block.Header.Height,
+			Time:   time.Unix(block.Header.Timestamp, 0).UTC(),
+			Hash:   tx.Hash,
+			Sender: transfer.Sender,
+			Amount: common.Fixed64(transfer.Amount).String(),
+		})
+	}
+	return transfers
+}
+
+// matchDonations links every transfer to the donation ledger record with the same transaction hash.
+func matchDonations(transfers []*IncomingTransfer, records []DonationRecord) {
+	byHash := make(map[string]*DonationRecord)
+	for i := range records {
+		byHash[records[i].Hash] = &records[i]
+	}
+	for _, transfer := range transfers {
+		transfer.Donation = byHash[transfer.Hash]
+	}
+}
+
+func printTransfers(transfers []*IncomingTransfer, blocks int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tHEIGHT\tFROM\tAMOUNT\tDONATION\tHASH")
+
+	var total, donated common.Fixed64
+	for _, transfer := range transfers {
+		amount, _ := common.StringToFixed64(transfer.Amount)
+		total += amount
+
+		donation := "-"
+		if transfer.Donation != nil {
+			donated += amount
+			donation = shortAddress(transfer.Donation.Src)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", transfer.Time.Local().Format(time.DateTime), transfer.Height,
+			transfer.Sender, transfer.Amount, donation, transfer.Hash)
+	}
+	w.Flush()
+
+	fmt.Println(len(transfers), "incoming transfers in the last", blocks, "blocks,", total.String(), "NKN, of which", donated.String(), "NKN matched the donation ledger")
+}
+
+// transfer sends amount NKN, or the whole balance minus the fee for "all", to a wallet address.
+func transfer(wallet *nkn.Wallet, address string, amount string, fee string, dryRun bool, yes bool) error {
+	if err := nkn.VerifyWalletAddress(address); err != nil {
+		return fmt.Errorf("%v is not a wallet address: %w", address, err)
+	}
+	feeFixed, err := common.StringToFixed64(fee)
+	if err != nil || feeFixed < 0 {
+		return fmt.Errorf("invalid fee: %v", fee)
+	}
+
+	balance, err := wallet.Balance()
+	if err != nil {
+		return fmt.Errorf("could not get balance: %w", err)
+	}
+	var amountFixed common.Fixed64
+	if amount == "all" {
+		amountFixed = balance.Fixed64 - feeFixed
+	} else if amountFixed, err = common.StringToFixed64(amount); err != nil {
+		return fmt.Errorf("invalid amount: %v", amount)
+	}
+	if amountFixed <= 0 {
+		return errors.New("nothing to transfer")
+	}
+	if amountFixed+feeFixed > balance.Fixed64 {
+		return fmt.Errorf("amount and fee are more than the balance of %v NKN", balance.String())
+	}
+
+	nonce, err := wallet.GetNonce(true)
+	if err != nil {
+		return fmt.Errorf("could not get nonce: %w", err)
+	}
+	tx, err := buildTransfer(wallet, address, amountFixed, feeFixed, nonce)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("From:     ", wallet.Address())
+	fmt.Println("To:       ", address)
+	fmt.Println("Amount:   ", amountFixed.String(), "NKN")
+	fmt.Println("Fee:      ", feeFixed.String(), "NKN")
+	fmt.Println("Balance:  ", balance.String(), "NKN, after:", (balance.Fixed64 - amountFixed - feeFixed).String(), "NKN")
+	fmt.Println("Nonce:    ", nonce)
+	hash := tx.Hash()
+	fmt.Println("Hash:     ", hash.ToHexString())
+	if dryRun {
+		raw, err := tx.Marshal()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Signed transaction:", hex.EncodeToString(raw))
+		fmt.Println("Dry run, nothing was sent")
+		return nil
+	}
+
+	if !yes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("not sending without confirmation, pass -yes")
+		}
+		fmt.Print("Send this transaction? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return errors.New("cancelled")
+		}
+	}
+
+	sent, err := wallet.SendRawTransaction(tx)
+	if err != nil {
+		return fmt.Errorf("could not send transaction: %w", err)
+	}
+	fmt.Println("Sent", sent)
+	return nil
+}
+
+// buildTransfer builds and signs a transfer transaction the way the SDK does, without sending it.
+func buildTransfer(wallet *nkn.Wallet, address string, amount common.Fixed64, fee common.Fixed64, nonce int64) (*transaction.Transaction, error) {
+	recipient, err := common.ToScriptHash(address)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := transaction.NewTransferAssetTransaction(wallet.ProgramHash(), recipient, uint64(nonce), amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := wallet.SignTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
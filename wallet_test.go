@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/hex"
+	"gonovon/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/common"
+)
+
+func mustWallet(t *testing.T, seed string) *nkn.Wallet {
+	b, _ := hex.DecodeString(seed)
+	account, err := nkn.NewAccount(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := nkn.NewWallet(account, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wallet
+}
+
+func TestIncomingTransfersMatchDonationLedger(t *testing.T) {
+	host := mustWallet(t, strings.Repeat("ab", 32))
+	viewer := mustWallet(t, strings.Repeat("cd", 32))
+
+	amount, _ := common.StringToFixed64("1.5")
+	tx, err := buildTransfer(viewer, host.Address(), amount, 0, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := tx.Hash()
+
+	block := &json.Block{Transactions: []json.Transaction{
+		{TxType: "TRANSFER_ASSET_TYPE", Hash: hash.ToHexString(), PayloadData: hex.EncodeToString(tx.UnsignedTx.Payload.Data)},
+		{TxType: "COINBASE_TYPE", Hash: "coinbase"},
+	}}
+	block.Header.Height = 42
+
+	transfers := blockTransfers(block, host.Address())
+	if len(transfers) != 1 {
+		t.Fatalf("expected 1 incoming transfer, got %v", len(transfers))
+	}
+	if transfers[0].Sender != viewer.Address() || transfers[0].Amount != "1.50000000" || transfers[0].Height != 42 {
+		t.Errorf("unexpected transfer: %+v", transfers[0])
+	}
+	if len(blockTransfers(block, viewer.Address())) != 0 {
+		t.Error("expected no transfers to the sender")
+	}
+
+	ledger := filepath.Join(t.TempDir(), "donations.jsonl")
+	os.WriteFile(ledger, []byte(`{"time":"2024-01-01T00:00:00Z","src":"viewer","amount":"1.5","id":"id","hash":"`+hash.ToHexString()+`"}`+"\n\n"), 0600)
+	records, err := readDonationLedger(ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matchDonations(transfers, records)
+	if transfers[0].Donation == nil || transfers[0].Donation.Src != "viewer" {
+		t.Errorf("expected the transfer to match the donation ledger, got %+v", transfers[0].Donation)
+	}
+
+	if records, err := readDonationLedger(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || len(records) != 0 {
+		t.Errorf("expected a missing ledger to have no records, got %v %v", records, err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gonovon/transport"
+	"gonovon/viewer"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const WATCH_PLAYLIST_SEGMENTS = 6
+
+// A segment id this far behind the last one, or a segment after this long without any, means the host restarted
+// and numbers its segments from 0 again.
+const WATCH_RESTART_JUMP = WATCH_PLAYLIST_SEGMENTS
+const WATCH_RESTART_GAP = 10 * time.Second
+
+// hlsGateway serves the segments of a viewer as a live HLS playlist. Segments are numbered by the gateway, the host
+// segment ids skip the segments that were lost.
+type hlsGateway struct {
+	segments []*hlsSegment
+	//nextSequence is the media sequence number of the next segment, discontinuities counts the discontinuities
+	//that left the playlist
+	nextSequence    int
+	discontinuities int
+	mutex           sync.RWMutex
+}
+
+type hlsSegment struct {
+	id            int
+	sequence      int
+	data          []byte
+	duration      float64
+	received      time.Time
+	discontinuity bool
+}
+
+// runWatch joins a channel as a viewer and serves it as a local HLS stream: gonovon watch <address>
+func runWatch(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:8090", "address of the local HLS server")
+	quality := flags.Int("quality", -1, "fixed quality level, 0 is source quality, -1 selects automatically")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonovon watch [flags] <address>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	host := flags.Arg(0)
+
+	viewerClient := createViewerClient()
+	defer viewerClient.Close()
+
+	startQuality := max(*quality, 0)
+	v := viewer.New(viewerClient, host, &viewer.Config{Quality: startQuality})
+	if err := v.Start(); err != nil {
+		log.Fatalln("could not join channel:", err)
+	}
+	defer v.Close()
+
+	info, err := v.ChannelInfo()
+	if err != nil {
+		log.Fatalln("could not get channel info:", err)
+	}
+	log.Println("Joined channel:", host, "mode:", info.Mode, "quality levels:", len(info.QualityLevels))
+
+	gateway := &hlsGateway{}
+	http.HandleFunc("/index.m3u8", gateway.servePlaylist)
+	http.HandleFunc("/", gateway.serveSegment)
+	go func() {
+		log.Fatalln(http.ListenAndServe(*listen, nil))
+	}()
+	log.Printf("Open http://%v/index.m3u8 in VLC, mpv or ffplay\n", *listen)
+
+	go func() {
+		for msg := range v.Chat {
+			role := ""
+			if msg.Role != "" {
+				role = "[" + msg.Role + "] "
+			}
+			fmt.Printf("%v%v: %v\n", role, shortAddress(msg.Src), msg.Text)
+		}
+	}()
+
+	go func() {
+		for text := range v.Text {
+			if text == viewer.STREAM_ENDED {
+				log.Println("The stream ended")
+				os.Exit(0)
+			}
+			fmt.Println(text)
+		}
+	}()
+
+	selector := &qualitySelector{
+		viewer: v,
+		auto:   *quality < 0,
+		levels: len(info.QualityLevels),
+	}
+
+	lastSegment := time.Time{}
+	lastQuality := v.Quality()
+	for segment := range v.Segments {
+		duration := 2.0
+		if !lastSegment.IsZero() && segment.Completed.Sub(lastSegment) <= WATCH_RESTART_GAP {
+			duration = segment.Completed.Sub(lastSegment).Seconds()
+		}
+		lastSegment = segment.Completed
+
+		gateway.add(&hlsSegment{
+			id:            segment.Id,
+			data:          segment.Data,
+			duration:      duration,
+			received:      segment.Completed,
+			discontinuity: v.Quality() != lastQuality,
+		})
+		lastQuality = v.Quality()
+
+		selector.update(segment, duration)
+	}
+}
+
+func createViewerClient() transport.Transport {
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	viewerClient, err := nkn.NewMultiClient(account, "", VIEWER_SUB_CLIENTS, false, &nkn.ClientConfig{
+		ConnectRetries:   10,
+		AllowUnencrypted: true,
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	<-viewerClient.OnConnect.C
+	log.Println("connected to NKN")
+
+	return transport.NewNKN(viewerClient, VIEWER_SUB_CLIENTS)
+}
+
+func (g *hlsGateway) add(segment *hlsSegment) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.segments) > 0 {
+		last := g.segments[len(g.segments)-1]
+		restarted := last.id-segment.id >= WATCH_RESTART_JUMP || segment.received.Sub(last.received) > WATCH_RESTART_GAP
+		//Late segments can not be added to a live playlist anymore
+		if segment.id <= last.id && !restarted {
+			return
+		}
+		//Players have to reset their decoder after a gap or a restart
+		if segment.id != last.id+1 {
+			segment.discontinuity = true
+		}
+	}
+
+	segment.sequence = g.nextSequence
+	g.nextSequence++
+	g.segments = append(g.segments, segment)
+	for len(g.segments) > WATCH_PLAYLIST_SEGMENTS {
+		if g.segments[0].discontinuity {
+			g.discontinuities++
+		}
+		g.segments = g.segments[1:]
+	}
+}
+
+func (g *hlsGateway) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if len(g.segments) == 0 {
+		http.Error(w, "no segments received yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	targetDuration := 1.0
+	for _, segment := range g.segments {
+		targetDuration = math.Max(targetDuration, math.Ceil(segment.duration))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:3\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%.0f\n", targetDuration))
+	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", g.segments[0].sequence))
+	sb.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", g.discontinuities))
+	for _, segment := range g.segments {
+		if segment.discontinuity {
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%d.ts\n", segment.duration, segment.sequence))
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(sb.String()))
+}
+
+func (g *hlsGateway) serveSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".ts"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	for _, segment := range g.segments {
+		if segment.sequence == id {
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write(segment.data)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// qualitySelector lowers the quality when segments take too long to arrive, and raises it when delivery is fast.
+type qualitySelector struct {
+	viewer *viewer.Viewer
+	auto   bool
+	levels int
+
+	slow      int
+	fast      int
+	discarded int
+}
+
+func (q *qualitySelector) update(segment *viewer.Segment, duration float64) {
+	if !q.auto || q.levels <= 1 {
+		return
+	}
+
+	stats := q.viewer.Stats()
+	lostSegment := stats.Discarded > q.discarded
+	q.discarded = stats.Discarded
+
+	deliveryRatio := segment.Completed.Sub(segment.FirstSeen).Seconds() / math.Max(duration, 0.1)
+	switch {
+	case lostSegment || deliveryRatio > 0.8:
+		q.slow++
+		q.fast = 0
+	case deliveryRatio < 0.3:
+		q.fast++
+		q.slow = 0
+	default:
+		q.slow = 0
+		q.fast = 0
+	}
+
+	current := q.viewer.Quality()
+	if q.slow >= 3 && current < q.levels-1 {
+		q.setQuality(current + 1)
+	} else if q.fast >= 10 && current > 0 {
+		q.setQuality(current - 1)
+	}
+}
+
+func (q *qualitySelector) setQuality(quality int) {
+	q.slow = 0
+	q.fast = 0
+	if _, err := q.viewer.SetQuality(quality); err != nil {
+		log.Println("error switching quality", err.Error())
+		return
+	}
+	log.Println("Switched to quality level:", quality)
+}
+
+func shortAddress(address string) string {
+	if len(address) > 8 {
+		return address[:8]
+	}
+	return address
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHLSGatewayNumbersSegments(t *testing.T) {
+	g := &hlsGateway{}
+	g.add(&hlsSegment{id: 40, data: []byte("a"), duration: 5})
+	g.add(&hlsSegment{id: 41, data: []byte("b"), duration: 2})
+	g.add(&hlsSegment{id: 41, data: []byte("late"), duration: 2})
+	g.add(&hlsSegment{id: 45, data: []byte("c"), duration: 2})
+
+	playlist := func() string {
+		w := httptest.NewRecorder()
+		g.servePlaylist(w, httptest.NewRequest("GET", "/index.m3u8", nil))
+		return w.Body.String()
+	}
+
+	expected := "#EXT-X-TARGETDURATION:5\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n" +
+		"#EXTINF:5.000,\n0.ts\n#EXTINF:2.000,\n1.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:2.000,\n2.ts\n"
+	if !strings.HasSuffix(playlist(), expected) {
+		t.Errorf("expected a sequence without gaps and a discontinuity at the lost segments:\n%v", playlist())
+	}
+
+	w := httptest.NewRecorder()
+	g.serveSegment(w, httptest.NewRequest("GET", "/2.ts", nil))
+	if w.Body.String() != "c" {
+		t.Errorf("expected segment 2 to be the one after the gap, got %q", w.Body.String())
+	}
+
+	for id := 46; id < 46+WATCH_PLAYLIST_SEGMENTS; id++ {
+		g.add(&hlsSegment{id: id, duration: 2})
+	}
+	if p := playlist(); !strings.Contains(p, "#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n") {
+		t.Errorf("expected the target duration and sequences to follow the window:\n%v", p)
+	}
+}
+
+func TestHLSGatewayContinuesAfterHostRestart(t *testing.T) {
+	start := time.Now()
+	g := &hlsGateway{}
+	add := func(id int, received time.Duration) {
+		g.add(&hlsSegment{id: id, data: []byte(strconv.Itoa(id)), duration: 2, received: start.Add(received)})
+	}
+	for id := 0; id < 3; id++ {
+		add(id, time.Duration(id)*2*time.Second)
+	}
+	add(1, 5*time.Second)
+
+	//A short stream restarted after a pause, then a long one restarted right away
+	add(0, time.Minute)
+	add(1, time.Minute+2*time.Second)
+	for id := 2; id < 2+WATCH_RESTART_JUMP; id++ {
+		add(id, time.Minute+time.Duration(id)*2*time.Second)
+	}
+	add(0, time.Minute+time.Duration(2+WATCH_RESTART_JUMP)*2*time.Second)
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	sequences := make([]string, 0)
+	for _, segment := range g.segments {
+		sequences = append(sequences, fmt.Sprintf("%v:%v:%v", segment.sequence, string(segment.data), segment.discontinuity))
+	}
+	expected := "6:3:false 7:4:false 8:5:false 9:6:false 10:7:false 11:0:true"
+	if strings.Join(sequences, " ") != expected {
+		t.Errorf("expected the restarted segments to follow with a discontinuity, got %v", sequences)
+	}
+	if g.nextSequence != 12 || g.discontinuities != 1 {
+		t.Errorf("expected the late segment to be dropped and one discontinuity to leave the window, got %v %v", g.nextSequence, g.discontinuities)
+	}
+}